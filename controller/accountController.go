@@ -1,24 +1,19 @@
 package controller
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
-type SessionData struct {
-	Username string
-	Expiry   time.Time
-}
-
-var Sessions = make(map[string]SessionData)
-
 func GetCookie(r *http.Request, tokenName string) *http.Cookie {
 	currentCookie, err := r.Cookie(tokenName)
 	if err != nil {
@@ -36,43 +31,27 @@ func GetUsernameFromCookie(r *http.Request, tokenName string) string {
 		return ""
 	}
 
-	session, ok := Sessions[cookie.Value]
+	session, ok := LookupSession(cookie.Value)
 	if !ok {
 		return ""
 	}
+	TouchSession(session)
 
 	return session.Username
 }
 
-func DoesUserMatchRank(r *http.Request, requiredRank string) bool {
-	currentUser := GetUsernameFromCookie(r, "userSessionToken")
-	userRankStr := GetUserRank(currentUser)
-
-	userRank, err1 := strconv.Atoi(userRankStr)
-	required, err2 := strconv.Atoi(requiredRank)
-
-	if err1 != nil || err2 != nil {
-		log.Printf("Rank conversion error: userRank=%s, requiredRank=%s\n", userRankStr, requiredRank)
-		return false
-	}
-
-	// fmt.Printf("%s: %d >= %d\n", currentUser, userRank, required)
-	return userRank >= required
-}
-
 func SetUserSessionCookie(w http.ResponseWriter, data UserData) {
-	sessionToken := uuid.NewString()
-	expiresAt := time.Now().Add(86400 * time.Second)
-
-	Sessions[sessionToken] = SessionData{
-		Username: data.Username,
-		Expiry:   expiresAt,
+	session, err := CreateSession(data.Username)
+	if err != nil {
+		log.Printf("Error creating session for %s: %v\n", data.Username, err)
+		return
 	}
+	RecordLastLogin(data.Username)
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     "userSessionToken",
-		Value:    sessionToken,
-		Expires:  expiresAt,
+		Value:    session.Token,
+		Expires:  session.Expiry,
 		SameSite: http.SameSiteLaxMode,
 		Path:     "/",
 	})
@@ -81,7 +60,8 @@ func SetUserSessionCookie(w http.ResponseWriter, data UserData) {
 type UserData struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
-	Rank     string `json:"rank"`
+	Role     string `json:"role"`
+	Invite   string `json:"invite,omitempty"`
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
@@ -92,10 +72,46 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !CheckCredentials(data.Username, data.Password) {
+	clientIP := GetClientIP(r)
+	if retryAfter := LoginThrottler.ReserveAttempt(data.Username, clientIP); retryAfter > 0 {
+		WriteTooManyRequests(w, retryAfter, "Too many failed login attempts, try again later")
+		return
+	}
+
+	username, ok := CheckCredentials(data.Username, data.Password)
+	if !ok {
+		LoginThrottler.RecordFailure(data.Username, clientIP)
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
+	LoginThrottler.RecordSuccess(data.Username, clientIP)
+
+	// from here on the account goes by its name as registered, whichever case it was typed in
+	data.Username = username
+
+	// only told after the password checked out, so bans can't be probed without knowing it
+	if ban, banned := GetActiveBan(data.Username); banned && !ban.ReadOnly {
+		fmt.Printf("Banned user of %s attempted to log in\n", data.Username)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "banned",
+			"error":  ban.Message(),
+		})
+		return
+	}
+
+	// no session until a reset password has been replaced through ChangePassword()
+	if MustChangePassword(data.Username) {
+		fmt.Printf("User of %s has to change their password before logging in\n", data.Username)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "password_change_required",
+			"error":  "Your password was reset, please choose a new one",
+		})
+		return
+	}
 
 	fmt.Printf("User of %s has requested login successfully\n", data.Username)
 	SetUserSessionCookie(w, data)
@@ -109,9 +125,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func Logout(w http.ResponseWriter, r *http.Request) {
-	// TODO: add in a session clear function here to prevent dead sessions from logout, maybe?
-
+func ClearUserSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "userSessionToken",
 		Value:    "",
@@ -120,6 +134,19 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   -1,
 		HttpOnly: true,
 	})
+}
+
+func Logout(w http.ResponseWriter, r *http.Request) {
+	// revoke the session on the server as well, otherwise a copied cookie would keep working
+	cookie := GetCookie(r, "userSessionToken")
+	if cookie != nil {
+		err := RevokeSession(cookie.Value)
+		if err != nil {
+			log.Printf("Error revoking session on logout: %v\n", err)
+		}
+	}
+
+	ClearUserSessionCookie(w)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -128,80 +155,687 @@ func Logout(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+/*
+logs the current user out of every device: revokes all sessions that belong to the username of the
+requester (including the current one) and clears the cookie
+*/
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	currentUsername := GetUsernameFromCookie(r, "userSessionToken")
+	if currentUsername == "" {
+		http.Error(w, "Not logged in!", http.StatusUnauthorized)
+		return
+	}
+
+	revoked, err := RevokeUserSessions(currentUsername)
+	if err != nil {
+		log.Printf("Error revoking sessions of %s: %v\n", currentUsername, err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("User of %s logged out everywhere, %d session(s) revoked\n", currentUsername, revoked)
+	ClearUserSessionCookie(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":          "logged_out",
+		"sessionsrevoked": strconv.FormatInt(revoked, 10),
+	})
+}
+
+// admin variant of LogoutAll(), revokes every session of any given username
+func RevokeUserSessionsAdmin(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch in RevokeUserSessionsAdmin, invalid perms!\n")
+		http.Error(w, "No permission to revoke sessions!", http.StatusForbidden)
+		return
+	}
+
+	var data UserData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	username, ok := canonicalUsernameOrError(w, data.Username)
+	if !ok {
+		return
+	}
+
+	revoked, err := RevokeUserSessions(username)
+	if err != nil {
+		log.Printf("Error revoking sessions of %s: %v\n", username, err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("Revoked %d session(s) of %s\n", revoked, username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":          "success",
+		"sessionsrevoked": strconv.FormatInt(revoked, 10),
+	})
+}
+
+var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// names that are displayed for anonymous / removed content, nobody should be able to register as them
+var reservedUsernames = []string{
+	"hidden", "deleted", "admin",
+}
+
+// returns an empty string whenever the username is valid, otherwise a message for why it isn't
+func ValidateUsername(username string) string {
+	if len(username) < 3 || len(username) > 24 {
+		return "Username must be between 3 and 24 characters long"
+	}
+
+	if !usernameRegex.MatchString(username) {
+		return "Username may only contain letters, numbers, underscores and dashes"
+	}
+
+	for _, reserved := range reservedUsernames {
+		if strings.EqualFold(username, reserved) {
+			return "Username is reserved"
+		}
+	}
+
+	return ""
+}
+
+// returns an empty string whenever the password is valid, otherwise a message for why it isn't
+func ValidatePassword(password string) string {
+	if len(password) < 8 {
+		return "Password must be at least 8 characters long"
+	}
+
+	// bcrypt only looks at the first 72 bytes, anything past that would silently be ignored
+	if len(password) > 72 {
+		return "Password must be at most 72 bytes long"
+	}
+
+	return ""
+}
+
+/*
+returns the username as it was registered for an account named username in any case, false if there's
+no such account. names are unique regardless of case (see 0014_unique_usernames.sql), so anything
+matching accounts by name has to go through this first and use the stored one from then on
+*/
+func CanonicalUsername(username string) (string, bool, error) {
+	var stored string
+	err := db.QueryRow(`
+		SELECT username FROM users WHERE username = ? COLLATE NOCASE
+	`, username).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return stored, true, nil
+}
+
+// CanonicalUsername() for handlers, writing the 404 (or 500) itself when there's no account named username
+func canonicalUsernameOrError(w http.ResponseWriter, username string) (string, bool) {
+	stored, exists, err := CanonicalUsername(username)
+	if err != nil {
+		log.Printf("Error looking up user %s: %v\n", username, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return "", false
+	}
+	if !exists {
+		WriteJSONError(w, http.StatusNotFound, "User does not exist")
+		return "", false
+	}
+
+	return stored, true
+}
+
+/*
+self-service registration, creates accounts with the "user" role depending on Cfg.RegistrationMode:
+
+  - "closed": nobody can register, accounts can only be created by admins through AddUser()
+
+  - "invite": registering requires a valid invite code, see inviteController.go
+
+  - "open": anybody can register, an invite code is optional but still honored for its preset role
+
+the username and password are validated, duplicates rejected (by the unique index on usernames, a
+check beforehand could race another registration of the same name) and the new user is logged in
+straight away. redeeming the invite and creating the account happen in one transaction, so a
+failed insert doesn't burn a use of the code
+*/
+func Register(w http.ResponseWriter, r *http.Request) {
+	if Cfg.RegistrationMode == RegistrationClosed {
+		WriteJSONError(w, http.StatusForbidden, "Registration is closed")
+		return
+	}
+
+	var data UserData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if Cfg.RegistrationMode == RegistrationInvite && data.Invite == "" {
+		WriteJSONError(w, http.StatusForbidden, "Registration requires an invite code")
+		return
+	}
+
+	if message := ValidateUsername(data.Username); message != "" {
+		WriteJSONError(w, http.StatusBadRequest, message)
+		return
+	}
+
+	if message := ValidatePassword(data.Password); message != "" {
+		WriteJSONError(w, http.StatusBadRequest, message)
+		return
+	}
+
+	hashedPassword, err := HashPassword(data.Password)
+	if err != nil {
+		log.Printf("Error hashing password on register: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting register transaction: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer tx.Rollback()
+
+	role := RoleUser
+	if data.Invite != "" {
+		role, err = RedeemInvite(tx, data.Invite, data.Username)
+		if err == ErrInvalidInvite {
+			WriteJSONError(w, http.StatusForbidden, "Invite code is invalid, expired or used up")
+			return
+		}
+		if err != nil {
+			log.Printf("Error redeeming invite on register: %v\n", err)
+			WriteJSONError(w, http.StatusInternalServerError, "Server error")
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO USERS (username, password, role)
+		VALUES (?, ?, ?)
+	`, data.Username, hashedPassword, role)
+	if IsUniqueConstraintError(err) {
+		WriteJSONError(w, http.StatusConflict, "Username is already taken")
+		return
+	}
+	if err != nil {
+		log.Printf("Error inserting user on register: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Error committing register transaction: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	fmt.Printf("User of %s has registered successfully (role %s)\n", data.Username, role)
+	SetUserSessionCookie(w, data)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "success",
+		"redirect": "/",
+	})
+}
+
 func AddUser(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "2") {
-		fmt.Printf("Rank mismatch when attempting to AddUser, invalid perms!\n")
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch when attempting to AddUser, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to add users!")
 		return
 	}
 
 	var data UserData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		log.Fatal(err)
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// same rules as Register(), admins can't create names that can't be registered either
+	if message := ValidateUsername(data.Username); message != "" {
+		WriteJSONError(w, http.StatusBadRequest, message)
+		return
+	}
+
+	if message := ValidatePassword(data.Password); message != "" {
+		WriteJSONError(w, http.StatusBadRequest, message)
+		return
+	}
+
+	if data.Role == "" {
+		data.Role = RoleUser
+	}
+	if !RoleExists(data.Role) {
+		WriteJSONError(w, http.StatusBadRequest, "Role does not exist")
+		return
 	}
 
 	hashedPassword, err := HashPassword(data.Password)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Error hashing password in AddUser: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	err = WriteToSQL(`
+		INSERT INTO USERS (username, password, role)
+		VALUES (?, ?, ?)
+	`, data.Username, hashedPassword, data.Role)
+	if IsUniqueConstraintError(err) {
+		WriteJSONError(w, http.StatusConflict, "Username is already taken")
+		return
+	}
+	if err != nil {
+		log.Printf("Error inserting user in AddUser: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
 	}
 
 	fmt.Printf("User Added\n")
 	fmt.Printf("Username: %s\n", data.Username)
-	fmt.Printf("Password: %s\n", hashedPassword)
-	fmt.Printf("Rank: %s\n", data.Rank)
+	fmt.Printf("Role: %s\n", data.Role)
 
-	WriteToSQL(`
-		INSERT INTO USERS (username, password, rank)
-		VALUES (?, ?, ?)
-	`, data.Username, hashedPassword, data.Rank)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+	})
+}
+
+const (
+	DeleteModeKeep  = "keep"
+	DeleteModePurge = "purge"
+
+	// posts and comments of deleted users are attributed to this, it can't be registered
+	DeletedUsername = "[deleted]"
+)
+
+/*
+struct for the data DeleteUser() takes
+  - Username: Username of the account to delete
+  - Mode: "keep" to keep their posts and comments attributed to "[deleted]", "purge" to remove them
+    (together with every comment on their posts and all of the uploaded files)
+*/
+type DeleteUserData struct {
+	Username string `json:"username"`
+	Mode     string `json:"mode"`
 }
 
+/*
+deletes a user together with whatever they left behind, depending on the mode (see DeleteUserData).
+the database side happens in a single transaction, files are only removed once it committed so a
+failed deletion never leaves posts pointing at missing uploads
+*/
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "2") {
-		fmt.Printf("Rank mismatch when attempting to DeleteUser, invalid perms!\n")
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch when attempting to DeleteUser, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to delete users!")
 		return
 	}
 
-	var data UserData
+	var data DeleteUserData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		log.Fatal(err)
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
 	}
 
-	WriteToSQL(`
-		DELETE FROM USERS WHERE username = ?
-	`, data.Username)
+	if data.Mode == "" {
+		data.Mode = DeleteModeKeep
+	}
+	if data.Mode != DeleteModeKeep && data.Mode != DeleteModePurge {
+		WriteJSONError(w, http.StatusBadRequest, "Mode has to be either keep or purge")
+		return
+	}
 
-	fmt.Printf("User of %s deleted successfully\n", data.Username)
-}
+	username, ok := canonicalUsernameOrError(w, data.Username)
+	if !ok {
+		return
+	}
 
-func GetUserRank(username string) string {
-	query, err := QueryFromSQL(`
-		SELECT rank FROM USERS WHERE username = ?
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction in DeleteUser: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer tx.Rollback()
+
+	// same last-admin protection as SetUserRank() (see userController.go)
+	res, err := tx.Exec(`
+		DELETE FROM USERS
+		WHERE username = ?
+		AND NOT (
+			role IN (`+adminRolesSQL+`)
+			AND (SELECT COUNT(*) FROM users WHERE role IN (`+adminRolesSQL+`)) <= 1
+		)
 	`, username)
 	if err != nil {
-		fmt.Println("Error validating GetUserRank, defaulting...")
-		query = ""
+		log.Printf("Error deleting user %s: %v\n", username, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		tx.Rollback()
+
+		if GetUserRole(username) == "" {
+			WriteJSONError(w, http.StatusNotFound, "User does not exist")
+			return
+		}
+
+		WriteJSONError(w, http.StatusConflict, "Can't delete the last remaining admin")
+		return
+	}
+
+	posts, comments, imagePaths, err := deleteUserContent(tx, username, data.Mode)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error deleting content of user %s: %v\n", username, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if _, err := RevokeUserSessions(username); err != nil {
+		log.Printf("Error revoking sessions of deleted user %s: %v\n", username, err)
 	}
 
-	return query
+	filesRemoved := ReleaseUploads(imagePaths...)
+
+	fmt.Printf("User of %s deleted successfully (%s: %d posts, %d comments, %d files)\n", username, data.Mode, posts, comments, filesRemoved)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":       "success",
+		"mode":         data.Mode,
+		"posts":        strconv.FormatInt(posts, 10),
+		"comments":     strconv.FormatInt(comments, 10),
+		"filesremoved": strconv.Itoa(filesRemoved),
+	})
 }
 
-func CheckCredentials(username, password string) bool {
-	passwordHash, err := QueryFromSQL(`
-		SELECT password FROM USERS WHERE username = ?
-	`, username)
+/*
+reattributes or purges the posts and comments of username inside tx, returning how many posts and
+comments were affected and, when purging, the uploads that have to be removed after committing. they're
+matched regardless of case, logins used to keep the name in whatever case it was typed in
+*/
+func deleteUserContent(tx *sql.Tx, username, mode string) (int64, int64, []string, error) {
+	if mode == DeleteModeKeep {
+		res, err := tx.Exec(`UPDATE posts SET username = ? WHERE username = ? COLLATE NOCASE`, DeletedUsername, username)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		posts, _ := res.RowsAffected()
+
+		res, err = tx.Exec(`UPDATE comments SET username = ? WHERE username = ? COLLATE NOCASE`, DeletedUsername, username)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		comments, _ := res.RowsAffected()
+
+		return posts, comments, nil, nil
+	}
+
+	imagePaths, err := queryUploadPaths(tx, `
+		SELECT imagepath, thumbpath FROM posts WHERE username = ? COLLATE NOCASE AND imagepath != ''
+		UNION ALL
+		SELECT imagepath, thumbpath FROM comments
+		WHERE imagepath != ''
+		AND (username = ? COLLATE NOCASE OR parentpostid IN (SELECT id FROM posts WHERE username = ? COLLATE NOCASE))
+	`, username, username, username)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	// revisions and comments go first, the ones on their posts can only be found while the posts still exist
+	_, err = tx.Exec(`
+		DELETE FROM revisions
+		WHERE targetid IN (SELECT id FROM posts WHERE username = ? COLLATE NOCASE)
+		OR targetid IN (
+			SELECT id FROM comments
+			WHERE username = ? COLLATE NOCASE OR parentpostid IN (SELECT id FROM posts WHERE username = ? COLLATE NOCASE)
+		)
+	`, username, username, username)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	res, err := tx.Exec(`
+		DELETE FROM comments
+		WHERE username = ? COLLATE NOCASE OR parentpostid IN (SELECT id FROM posts WHERE username = ? COLLATE NOCASE)
+	`, username, username)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	comments, _ := res.RowsAffected()
+
+	res, err = tx.Exec(`DELETE FROM posts WHERE username = ? COLLATE NOCASE`, username)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	posts, _ := res.RowsAffected()
+
+	return posts, comments, imagePaths, nil
+}
+
+type PasswordChangeData struct {
+	Username        string `json:"username"`
+	CurrentPassword string `json:"currentpassword"`
+	NewPassword     string `json:"newpassword"`
+}
+
+func MustChangePassword(username string) bool {
+	var mustChange bool
+	err := db.QueryRow(`
+		SELECT must_change_password FROM users WHERE username = ? COLLATE NOCASE
+	`, username).Scan(&mustChange)
+	if err != nil {
+		return false
+	}
+
+	return mustChange
+}
+
+/*
+changes the password of a user, which requires their current password either way:
+
+  - logged in users change their own password, every other session of theirs gets revoked
+
+  - users that were forced to change their password after a reset aren't logged in yet, so they pass
+    their username along and get logged in once the new password is set
+*/
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var data PasswordChangeData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	username := GetUsernameFromCookie(r, "userSessionToken")
+	loggedIn := username != ""
+	if !loggedIn {
+		username = data.Username
+	}
+
+	// this checks passwords just like Login() does, so it goes through the same throttle
+	clientIP := GetClientIP(r)
+	if retryAfter := LoginThrottler.ReserveAttempt(username, clientIP); retryAfter > 0 {
+		WriteTooManyRequests(w, retryAfter, "Too many failed attempts, try again later")
+		return
+	}
+
+	storedUsername, ok := CheckCredentials(username, data.CurrentPassword)
+	if username == "" || !ok {
+		LoginThrottler.RecordFailure(username, clientIP)
+		WriteJSONError(w, http.StatusUnauthorized, "Current password is incorrect")
+		return
+	}
+	LoginThrottler.RecordSuccess(username, clientIP)
+	username = storedUsername
+
+	if message := ValidatePassword(data.NewPassword); message != "" {
+		WriteJSONError(w, http.StatusBadRequest, message)
+		return
+	}
+
+	if data.NewPassword == data.CurrentPassword {
+		WriteJSONError(w, http.StatusBadRequest, "New password must differ from the current one")
+		return
+	}
+
+	hashedPassword, err := HashPassword(data.NewPassword)
+	if err != nil {
+		log.Printf("Error hashing password in ChangePassword: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	err = WriteToSQL(`
+		UPDATE users SET password = ?, must_change_password = 0 WHERE username = ?
+	`, hashedPassword, username)
+	if err != nil {
+		log.Printf("Error updating password in ChangePassword: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if loggedIn {
+		cookie := GetCookie(r, "userSessionToken")
+		_, err = RevokeOtherUserSessions(username, cookie.Value)
+	} else {
+		_, err = RevokeUserSessions(username)
+		if ban, banned := GetActiveBan(username); !banned || ban.ReadOnly {
+			SetUserSessionCookie(w, UserData{Username: username})
+		}
+	}
+	if err != nil {
+		log.Printf("Error revoking sessions in ChangePassword: %v\n", err)
+	}
+
+	fmt.Printf("User of %s changed their password\n", username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "success",
+		"redirect": "/",
+	})
+}
+
+/*
+admin password reset: sets a random temporary password that is handed back to the admin, forces the
+user to change it at their next login and revokes all of their sessions
+*/
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch in ResetPassword, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to reset passwords!")
+		return
+	}
+
+	var data UserData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	username, ok := canonicalUsernameOrError(w, data.Username)
+	if !ok {
+		return
+	}
+
+	temporaryPassword, err := GenerateRandomHex(8)
+	if err != nil {
+		log.Printf("Error generating temporary password: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	hashedPassword, err := HashPassword(temporaryPassword)
+	if err != nil {
+		log.Printf("Error hashing password in ResetPassword: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	res, err := db.Exec(`
+		UPDATE users SET password = ?, must_change_password = 1 WHERE username = ?
+	`, hashedPassword, username)
+	if err != nil {
+		log.Printf("Error updating password in ResetPassword: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	// deleted (or renamed) since it was looked up, the temporary password would be for nobody
+	if updated, _ := res.RowsAffected(); updated == 0 {
+		WriteJSONError(w, http.StatusNotFound, "User does not exist")
+		return
+	}
+
+	revoked, err := RevokeUserSessions(username)
+	if err != nil {
+		log.Printf("Error revoking sessions in ResetPassword: %v\n", err)
+	}
+
+	fmt.Printf("Password of %s has been reset, %d session(s) revoked\n", username, revoked)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":            "success",
+		"temporarypassword": temporaryPassword,
+	})
+}
+
+/*
+checks password against the account of username, returning the username as it was registered. like
+the unique index on them, usernames are matched regardless of case, so "Sannu" can log in as "sannu"
+*/
+func CheckCredentials(username, password string) (string, bool) {
+	var storedUsername, passwordHash string
+	err := db.QueryRow(`
+		SELECT username, password FROM USERS WHERE username = ? COLLATE NOCASE
+	`, username).Scan(&storedUsername, &passwordHash)
 	if err != nil {
 		fmt.Println("Error validating password, defaulting...")
 		passwordHash = ""
 	}
-	verifiedPassword := VerifyPassword(password, passwordHash)
 
-	return verifiedPassword
+	if !VerifyPassword(password, passwordHash) {
+		return "", false
+	}
+
+	return storedUsername, true
 }
 
+// bcrypt cost new passwords are hashed with, existing hashes keep whatever cost they were made with
+var passwordHashCost = 14
+
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
 	return string(bytes), err
 }
 
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// registrations of the same name (in different cases) racing each other, only one of them may win
+func TestRegisterConcurrentDuplicates(t *testing.T) {
+	openTestDB(t)
+	Cfg.RegistrationMode = RegistrationOpen
+
+	names := []string{"alice", "Alice", "ALICE", "aLiCe"}
+	codes := make([]int, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+
+			body := fmt.Sprintf(`{"username": %q, "password": "correct horse"}`, name)
+			rec := httptest.NewRecorder()
+			Register(rec, httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(body)))
+			codes[i] = rec.Code
+		}(i, name)
+	}
+	wg.Wait()
+
+	created, conflicts := 0, 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			created++
+		case http.StatusConflict:
+			conflicts++
+		default:
+			t.Errorf("Register() = %d", code)
+		}
+	}
+	if created != 1 || conflicts != len(names)-1 {
+		t.Errorf("%d registered and %d conflicts, want 1 and %d", created, conflicts, len(names)-1)
+	}
+
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM users WHERE username = 'alice' COLLATE NOCASE`).Scan(&count)
+	if count != 1 {
+		t.Errorf("%d accounts named alice", count)
+	}
+}
+
+func TestAddUserDuplicate(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "sannu", "correct horse", RoleAdmin)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+
+	session, err := Sessions.Create("sannu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/addUser", strings.NewReader(`{"username": "ALICE", "password": "correct horse"}`))
+	req.AddCookie(&http.Cookie{Name: "userSessionToken", Value: session.Token})
+	rec := httptest.NewRecorder()
+	AddUser(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("AddUser() of a taken name = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// logging in works in any case, but the session goes to the name as registered
+func TestLoginCaseInsensitive(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+
+	rec := httptest.NewRecorder()
+	Login(rec, loginRequest("ALICE", "correct horse"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Login() = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Login() set %d cookies", len(cookies))
+	}
+
+	session, ok := LookupSession(cookies[0].Value)
+	if !ok || session.Username != "alice" {
+		t.Fatalf("session after logging in as ALICE = %v, %v", session, ok)
+	}
+}
+
+// the unique username migration has to stop instead of skipping the index while duplicates exist
+func TestUniqueUsernameMigrationFailsOnDuplicates(t *testing.T) {
+	openTestDB(t)
+
+	// back to before 0014_unique_usernames, with duplicates that were possible back then
+	for _, statement := range []string{
+		`DROP INDEX users_username_unique`,
+		`DELETE FROM schema_version WHERE version >= 14`,
+		`INSERT INTO users (username, password, role) VALUES ('bob', '', 'user'), ('Bob', '', 'user')`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := MigrateSQL()
+	if err == nil || !IsUniqueConstraintError(err) {
+		t.Fatalf("MigrateSQL() with duplicate usernames = %v", err)
+	}
+
+	version, _ := CurrentSchemaVersion()
+	if version != 13 {
+		t.Fatalf("schema version %d after the failed migration", version)
+	}
+}
+
+// a reset for the name in another case still resets (and logs out) the account as it was registered
+func TestResetPasswordAnyCase(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "sannu", "correct horse", RoleAdmin)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+
+	session, err := Sessions.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	ResetPassword(rec, requestAs(t, "sannu", http.MethodPost, "/api/resetPassword", bytes.NewBufferString(`{"username": "Alice"}`), "application/json"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ResetPassword() = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var res map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := CheckCredentials("alice", res["temporarypassword"]); !ok {
+		t.Error("the temporary password doesn't work for alice")
+	}
+	if _, ok := LookupSession(session.Token); ok {
+		t.Error("alice's session survived the reset")
+	}
+
+	rec = httptest.NewRecorder()
+	ResetPassword(rec, requestAs(t, "sannu", http.MethodPost, "/api/resetPassword", bytes.NewBufferString(`{"username": "nobody"}`), "application/json"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("ResetPassword() of a missing user = %d", rec.Code)
+	}
+}
+
+// sends body as JSON to handler as sannu (who has to be an admin), failing the test on anything but want
+func adminRequest(t *testing.T, handler http.HandlerFunc, body string, want int) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler(rec, requestAs(t, "sannu", http.MethodPost, "/api/admin", bytes.NewBufferString(body), "application/json"))
+	if rec.Code != want {
+		t.Fatalf("%s = %d, want %d: %s", body, rec.Code, want, rec.Body.String())
+	}
+}
+
+// admin actions find the account whatever case its name is given in, and act on it as registered
+func TestAdminActionsAnyCase(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "sannu", "correct horse", RoleAdmin)
+	addTestUser(t, "bob", "correct horse", RoleUser)
+
+	// logged in as "BOB" back when sessions kept the name as it was typed
+	legacy, err := Sessions.Create("BOB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminRequest(t, SetUserRank, `{"username": "BOB", "role": "admin"}`, http.StatusOK)
+	if role := GetUserRole("bob"); role != RoleAdmin {
+		t.Fatalf("role of bob = %q", role)
+	}
+	adminRequest(t, SetUserRank, `{"username": "Bob", "role": "user"}`, http.StatusOK)
+
+	adminRequest(t, BanUser, `{"username": "BOB", "reason": "spam"}`, http.StatusOK)
+	if _, banned := GetActiveBan("bob"); !banned {
+		t.Fatal("bob isn't banned")
+	}
+	if _, banned := GetActiveBan("Bob"); !banned {
+		t.Fatal("Bob isn't banned")
+	}
+	if _, ok := LookupSession(legacy.Token); ok {
+		t.Fatal("session of BOB survived the ban")
+	}
+
+	adminRequest(t, UnbanUser, `{"username": "bOb"}`, http.StatusOK)
+	if _, banned := GetActiveBan("bob"); banned {
+		t.Fatal("bob is still banned")
+	}
+
+	session, err := Sessions.Create("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminRequest(t, RevokeUserSessionsAdmin, `{"username": "BOB"}`, http.StatusOK)
+	if _, ok := LookupSession(session.Token); ok {
+		t.Fatal("session of bob survived revoking the sessions of BOB")
+	}
+
+	if _, err := db.Exec(`INSERT INTO posts (id, username, postcontent, imagepath) VALUES (1, 'Bob', 'hi', '')`); err != nil {
+		t.Fatal(err)
+	}
+	adminRequest(t, DeleteUser, `{"username": "BOB", "mode": "keep"}`, http.StatusOK)
+	if GetUserRole("bob") != "" {
+		t.Fatal("bob still exists")
+	}
+	var author string
+	db.QueryRow(`SELECT username FROM posts WHERE id = 1`).Scan(&author)
+	if author != DeletedUsername {
+		t.Fatalf("post of bob attributed to %q", author)
+	}
+
+	adminRequest(t, SetUserRank, `{"username": "bob", "role": "user"}`, http.StatusNotFound)
+	adminRequest(t, BanUser, `{"username": "bob"}`, http.StatusNotFound)
+}
+
+// admins are held to the same rules as Register() when adding accounts
+func TestAddUserValidates(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "sannu", "correct horse", RoleAdmin)
+
+	for _, body := range []string{
+		`{"username": "[deleted]", "password": "correct horse"}`,
+		`{"username": "Deleted", "password": "correct horse"}`,
+		`{"username": "<img src=x onerror=alert(1)>", "password": "correct horse"}`,
+		`{"username": "al", "password": "correct horse"}`,
+		`{"username": "alice", "password": "short"}`,
+		`{"username": "alice", "password": "` + strings.Repeat("a", 73) + `"}`,
+	} {
+		adminRequest(t, AddUser, body, http.StatusBadRequest)
+	}
+
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count)
+	if count != 1 {
+		t.Fatalf("%d account(s) were added anyway", count)
+	}
+
+	adminRequest(t, AddUser, `{"username": "alice", "password": "`+strings.Repeat("a", 72)+`"}`, http.StatusOK)
+}
@@ -17,8 +17,8 @@ NOTE: currently only has support for one announcement, maybe add a history or ma
 for later
 */
 func AddAnnouncement(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "2") {
-		fmt.Printf("Rank mismatch in AddAnnouncement, invalid perms!\n")
+	if !HasPermission(r, PermManageAnnouncements) {
+		fmt.Printf("Permission mismatch in AddAnnouncement, invalid perms!\n")
 		http.Error(w, "Invalid permission trying to add announcement!", http.StatusUnsupportedMediaType)
 		return
 	}
@@ -37,7 +37,7 @@ func AddAnnouncement(w http.ResponseWriter, r *http.Request) {
 }
 
 func RemoveAnnouncement(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "2") {
+	if !HasPermission(r, PermManageAnnouncements) {
 		http.Error(w, "Invalid permission trying to remove announcement!", http.StatusUnsupportedMediaType)
 		return
 	}
@@ -53,8 +53,8 @@ func RemoveAnnouncement(w http.ResponseWriter, r *http.Request) {
 }
 
 func RequestAnnouncement(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "1") {
-		fmt.Printf("Rank mismatch in RequestAnnouncement, invalid perms!\n")
+	if !HasPermission(r, PermRead) {
+		fmt.Printf("Permission mismatch in RequestAnnouncement, invalid perms!\n")
 		http.Error(w, "Invalid permission trying to request announcement!", http.StatusUnsupportedMediaType)
 		return
 	}
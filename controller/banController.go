@@ -0,0 +1,289 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	bans and timed suspensions, so that misbehaving accounts can be stopped without deleting them (and
+	with them, their history).
+
+	a ban is either permanent or runs out after a set amount of hours, and is either a full ban or a
+	read-only one:
+	  - full bans stop the user from logging in at all, every session they have is revoked right away
+	  - read-only bans still let the user log in and read, but not post or comment
+
+	both are enforced in HasPermission(), so every handler asking for a permission respects them. active
+	bans are kept in memory (like the role permissions are), LoadBansFromDB() refreshes them and is called
+	whenever someone gets banned or unbanned. a user only ever has one active ban, banning them again
+	replaces it
+*/
+
+/*
+struct for ban-related data that we can assemble and serve
+  - Username: Username of the banned account
+  - Reason: why the account was banned, shown to the user
+  - ReadOnly: whether the user can still log in and read
+  - DurationHours: only used when banning, how long until the ban runs out (0 for permanent)
+  - ExpiresAt: timestamp of when the ban runs out, empty for permanent
+  - CreatedBy: username of who issued the ban
+  - CreatedAt: timestamp of when the ban was issued
+*/
+type BanData struct {
+	Username      string `json:"username"`
+	Reason        string `json:"reason"`
+	ReadOnly      bool   `json:"readonly"`
+	DurationHours int    `json:"durationhours,omitempty"`
+	ExpiresAt     string `json:"expiresat"`
+	CreatedBy     string `json:"createdby"`
+	CreatedAt     string `json:"createdat"`
+
+	expiry time.Time
+}
+
+var (
+	activeBansMu sync.RWMutex
+	activeBans   map[string]BanData
+)
+
+// the message shown to a banned user whenever the ban stops them from doing something
+func (b BanData) Message() string {
+	var message string
+	if b.ReadOnly {
+		message = "Your account is restricted to read-only"
+	} else {
+		message = "Your account is banned"
+	}
+
+	if b.expiry.IsZero() {
+		message += " permanently"
+	} else {
+		message += " until " + b.ExpiresAt
+	}
+
+	if b.Reason != "" {
+		message += ", reason: " + b.Reason
+	}
+
+	return message
+}
+
+func (b BanData) Expired() bool {
+	return !b.expiry.IsZero() && time.Now().After(b.expiry)
+}
+
+func queryActiveBans() ([]BanData, error) {
+	rows, err := db.Query(`
+		SELECT username, reason, read_only, expires_at, created_by, created_at
+		FROM bans
+		WHERE lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY created_at DESC
+	`, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []BanData{}
+	for rows.Next() {
+		var ban BanData
+		var expiresAt sql.NullInt64
+
+		err := rows.Scan(&ban.Username, &ban.Reason, &ban.ReadOnly, &expiresAt, &ban.CreatedBy, &ban.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if expiresAt.Valid {
+			ban.expiry = time.Unix(expiresAt.Int64, 0)
+			ban.ExpiresAt = ban.expiry.UTC().Format(time.RFC3339)
+		}
+
+		bans = append(bans, ban)
+	}
+
+	return bans, rows.Err()
+}
+
+func LoadBansFromDB() error {
+	bans, err := queryActiveBans()
+	if err != nil {
+		return err
+	}
+
+	// keyed by the lowercased name, usernames are unique regardless of case (see CanonicalUsername())
+	byUsername := make(map[string]BanData, len(bans))
+	for _, ban := range bans {
+		byUsername[strings.ToLower(ban.Username)] = ban
+	}
+
+	activeBansMu.Lock()
+	activeBans = byUsername
+	activeBansMu.Unlock()
+
+	return nil
+}
+
+// returns the active ban of username (in any case), false if they aren't banned (anymore)
+func GetActiveBan(username string) (BanData, bool) {
+	activeBansMu.RLock()
+	ban, ok := activeBans[strings.ToLower(username)]
+	activeBansMu.RUnlock()
+
+	if !ok || ban.Expired() {
+		return BanData{}, false
+	}
+
+	return ban, true
+}
+
+func BanUser(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermBanUsers) {
+		fmt.Printf("Permission mismatch in BanUser, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to ban users!")
+		return
+	}
+
+	var data BanData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	username, ok := canonicalUsernameOrError(w, data.Username)
+	if !ok {
+		return
+	}
+	role := GetUserRole(username)
+
+	// staff can't ban each other, they have to be demoted first
+	if RoleHasPermission(role, PermBanUsers) {
+		WriteJSONError(w, http.StatusForbidden, "Can't ban a user that can ban others, change their role first")
+		return
+	}
+
+	if data.DurationHours < 0 {
+		WriteJSONError(w, http.StatusBadRequest, "Duration can't be negative")
+		return
+	}
+
+	var expiresAt sql.NullInt64
+	if data.DurationHours > 0 {
+		expiresAt.Int64 = time.Now().Add(time.Duration(data.DurationHours) * time.Hour).Unix()
+		expiresAt.Valid = true
+	}
+
+	currentUsername := GetUsernameFromCookie(r, "userSessionToken")
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting ban transaction: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE bans SET lifted_by = ?, lifted_at = CURRENT_TIMESTAMP
+		WHERE username = ? COLLATE NOCASE AND lifted_at IS NULL
+	`, currentUsername, username)
+	if err == nil {
+		_, err = tx.Exec(`
+			INSERT INTO bans (username, reason, read_only, expires_at, created_by)
+			VALUES (?, ?, ?, ?, ?)
+		`, username, data.Reason, data.ReadOnly, expiresAt, currentUsername)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error banning %s: %v\n", username, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if err := LoadBansFromDB(); err != nil {
+		log.Printf("Error reloading bans: %v\n", err)
+	}
+
+	if !data.ReadOnly {
+		if _, err := RevokeUserSessions(username); err != nil {
+			log.Printf("Error revoking sessions of banned user %s: %v\n", username, err)
+		}
+	}
+
+	fmt.Printf("User of %s banned by %s (read-only: %t, hours: %d)\n", username, currentUsername, data.ReadOnly, data.DurationHours)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+	})
+}
+
+func UnbanUser(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermBanUsers) {
+		fmt.Printf("Permission mismatch in UnbanUser, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to unban users!")
+		return
+	}
+
+	var data BanData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	currentUsername := GetUsernameFromCookie(r, "userSessionToken")
+	res, err := db.Exec(`
+		UPDATE bans SET lifted_by = ?, lifted_at = CURRENT_TIMESTAMP
+		WHERE username = ? COLLATE NOCASE AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+	`, currentUsername, data.Username, time.Now().Unix())
+	if err != nil {
+		log.Printf("Error unbanning %s: %v\n", data.Username, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		WriteJSONError(w, http.StatusNotFound, "User is not banned")
+		return
+	}
+
+	if err := LoadBansFromDB(); err != nil {
+		log.Printf("Error reloading bans: %v\n", err)
+	}
+
+	fmt.Printf("User of %s unbanned by %s\n", data.Username, currentUsername)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+	})
+}
+
+func ListBans(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermBanUsers) {
+		fmt.Printf("Permission mismatch in ListBans, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to view bans!")
+		return
+	}
+
+	bans, err := queryActiveBans()
+	if err != nil {
+		log.Printf("Error querying bans: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bans)
+}
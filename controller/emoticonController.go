@@ -35,8 +35,8 @@ func LoadEmoticonsFromDB() error {
 // TODO: add a proper message for the front-end / error handling whenever emoticon can or can't be manipulated
 
 func AddEmoticon(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "2") {
-		fmt.Printf("Rank mismatch in AddEmoticon, invalid perms!\n")
+	if !HasPermission(r, PermManageEmoticons) {
+		fmt.Printf("Permission mismatch in AddEmoticon, invalid perms!\n")
 		http.Error(w, "Invalid permission trying to add new emoticon!", http.StatusUnsupportedMediaType)
 		return
 	}
@@ -55,7 +55,7 @@ func AddEmoticon(w http.ResponseWriter, r *http.Request) {
 }
 
 func DeleteEmoticon(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "2") {
+	if !HasPermission(r, PermManageEmoticons) {
 		http.Error(w, "Invalid permission trying to delete emoticon!", http.StatusUnsupportedMediaType)
 		return
 	}
@@ -72,8 +72,9 @@ func DeleteEmoticon(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+var emoticonRegex = regexp.MustCompile(`:([a-zA-Z0-9_+-]+):`)
+
 func RegexEmoticons(contentString string) string {
-	var emoticonRegex = regexp.MustCompile(`:([a-zA-Z0-9_+-]+):`)
 	contentString = emoticonRegex.ReplaceAllStringFunc(contentString, func(match string) string {
 		name := match[1 : len(match)-1]
 		if EmoticonSet[name] {
@@ -2,14 +2,19 @@ package controller
 
 import (
 	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"io"
-	"mime/multipart"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -21,19 +26,185 @@ import (
 	anything that doesn't fit into specific main post stuff
 */
 
+/*
+struct for the configuration of the server, loaded from the environment (or a .env file)
+  - ServerAddress: address the server listens on
+  - ServerPort: port the server listens on
+  - RegistrationMode: whether strangers can register: "open", "invite" (invite-only) or "closed"
+  - TrustProxy: whether to take the client IP from X-Forwarded-For, only for running behind a proxy
+  - EditWindow: how long after submitting users can still edit their own posts and comments (0 for never)
+  - TrashRetention: how long deleted posts and comments stay in the trash before being purged (0 for forever)
+  - UploadCheck: what the background upload check does: "report" problems, "fix" them or "off"
+  - Storage: where uploads are stored: "local" (the uploads/ directory) or "s3" (see storageController.go)
+  - S3Endpoint, S3Region, S3Bucket: where the bucket is, only for the "s3" storage
+  - S3AccessKey, S3SecretKey: credentials for the bucket, only for the "s3" storage
+  - S3PathStyle: whether the bucket goes into the URL path (MinIO) instead of the host name (AWS)
+  - UploadMaxSize: largest file that can be uploaded, in bytes
+  - UploadMaxWidth, UploadMaxHeight: largest images and videos that can be uploaded, in pixels
+  - UploadMaxDuration: longest video clip that can be uploaded
+  - UploadTypes: which kinds of files can be uploaded, see uploadTypes for the names
+*/
 type Config struct {
-	ServerAddress string
-	ServerPort    string
+	ServerAddress     string
+	ServerPort        string
+	RegistrationMode  string
+	TrustProxy        bool
+	EditWindow        time.Duration
+	TrashRetention    time.Duration
+	UploadCheck       string
+	Storage           string
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKey       string
+	S3SecretKey       string
+	S3PathStyle       bool
+	UploadMaxSize     int64
+	UploadMaxWidth    int
+	UploadMaxHeight   int
+	UploadMaxDuration time.Duration
+	UploadTypes       []string
 }
 
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
 var Cfg *Config
 
 func LoadConfig() {
 	_ = godotenv.Load()
 
+	var err error
 	Cfg = &Config{
-		ServerAddress: getEnv("SERVER_ADDRESS", "localhost"),
-		ServerPort:    getEnv("SERVER_PORT", "1759"),
+		ServerAddress:    getEnv("SERVER_ADDRESS", "localhost"),
+		ServerPort:       getEnv("SERVER_PORT", "1759"),
+		RegistrationMode: getEnv("REGISTRATION_MODE", RegistrationClosed),
+		TrustProxy:       ParseBoolOrFalse(getEnv("TRUST_PROXY", "false")),
+		UploadCheck:      getEnv("UPLOAD_CHECK", UploadCheckReport),
+		Storage:          getEnv("STORAGE", StorageLocal),
+		S3Endpoint:       getEnv("S3_ENDPOINT", ""),
+		S3Region:         getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:         getEnv("S3_BUCKET", ""),
+		S3AccessKey:      getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:      getEnv("S3_SECRET_KEY", ""),
+		S3PathStyle:      ParseBoolOrFalse(getEnv("S3_PATH_STYLE", "true")),
+	}
+
+	switch Cfg.RegistrationMode {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+	default:
+		fmt.Printf("Unknown REGISTRATION_MODE of %s, defaulting to closed...\n", Cfg.RegistrationMode)
+		Cfg.RegistrationMode = RegistrationClosed
+	}
+
+	switch Cfg.UploadCheck {
+	case UploadCheckOff, UploadCheckReport, UploadCheckFix:
+	default:
+		fmt.Printf("Unknown UPLOAD_CHECK of %s, defaulting to report...\n", Cfg.UploadCheck)
+		Cfg.UploadCheck = UploadCheckReport
+	}
+
+	switch Cfg.Storage {
+	case StorageLocal, StorageS3:
+	default:
+		fmt.Printf("Unknown STORAGE of %s, defaulting to local...\n", Cfg.Storage)
+		Cfg.Storage = StorageLocal
+	}
+
+	editWindow := getEnv("EDIT_WINDOW", "15m")
+	Cfg.EditWindow, err = time.ParseDuration(editWindow)
+	if err != nil || Cfg.EditWindow < 0 {
+		fmt.Printf("Invalid EDIT_WINDOW of %s, defaulting to 15m...\n", editWindow)
+		Cfg.EditWindow = 15 * time.Minute
+	}
+
+	trashRetention := getEnv("TRASH_RETENTION", "720h")
+	Cfg.TrashRetention, err = time.ParseDuration(trashRetention)
+	if err != nil || Cfg.TrashRetention < 0 {
+		fmt.Printf("Invalid TRASH_RETENTION of %s, defaulting to 720h...\n", trashRetention)
+		Cfg.TrashRetention = 720 * time.Hour
+	}
+
+	uploadMaxSize := getEnv("UPLOAD_MAX_SIZE", "10MB")
+	Cfg.UploadMaxSize, err = ParseByteSize(uploadMaxSize)
+	if err != nil || Cfg.UploadMaxSize <= 0 {
+		fmt.Printf("Invalid UPLOAD_MAX_SIZE of %s, defaulting to 10MB...\n", uploadMaxSize)
+		Cfg.UploadMaxSize = 10 << 20
+	}
+
+	uploadMaxDimensions := getEnv("UPLOAD_MAX_DIMENSIONS", "8192x8192")
+	_, err = fmt.Sscanf(uploadMaxDimensions, "%dx%d", &Cfg.UploadMaxWidth, &Cfg.UploadMaxHeight)
+	if err != nil || Cfg.UploadMaxWidth <= 0 || Cfg.UploadMaxHeight <= 0 {
+		fmt.Printf("Invalid UPLOAD_MAX_DIMENSIONS of %s, defaulting to 8192x8192...\n", uploadMaxDimensions)
+		Cfg.UploadMaxWidth, Cfg.UploadMaxHeight = 8192, 8192
+	}
+
+	uploadMaxDuration := getEnv("UPLOAD_MAX_DURATION", "30s")
+	Cfg.UploadMaxDuration, err = time.ParseDuration(uploadMaxDuration)
+	if err != nil || Cfg.UploadMaxDuration <= 0 {
+		fmt.Printf("Invalid UPLOAD_MAX_DURATION of %s, defaulting to 30s...\n", uploadMaxDuration)
+		Cfg.UploadMaxDuration = 30 * time.Second
+	}
+
+	for _, name := range strings.Split(getEnv("UPLOAD_TYPES", "jpeg,png,gif,webp,webm,mp4"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := uploadTypes[name]; !ok {
+			fmt.Printf("Unknown upload type %s in UPLOAD_TYPES, ignoring it...\n", name)
+			continue
+		}
+		Cfg.UploadTypes = append(Cfg.UploadTypes, name)
+	}
+}
+
+/*
+parses a size like "10MB", "512KB" or "1048576" into bytes. KB, MB and GB go by 1024, the way file
+managers count
+*/
+func ParseByteSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(size, unit.suffix) {
+			size = strings.TrimSpace(strings.TrimSuffix(size, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size out of range")
+	}
+
+	return n * multiplier, nil
+}
+
+// the opposite of ParseByteSize(), for showing sizes in messages
+func FormatByteSize(size int64) string {
+	switch {
+	case size >= 1<<30 && size%(1<<30) == 0:
+		return fmt.Sprintf("%dGB", size>>30)
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", size>>20)
+	case size >= 1<<10 && size%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", size>>10)
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1<<20))
+	default:
+		return fmt.Sprintf("%dB", size)
 	}
 }
 
@@ -44,6 +215,46 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// writes a JSON error response of {"status": "error", "error": message} the front-end can display
+func WriteJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "error",
+		"error":  message,
+	})
+}
+
+// returns a random hex string of n bytes (so 2n characters), for codes and temporary passwords
+func GenerateRandomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+/*
+returns the IP of whoever sent the request. X-Forwarded-For is only looked at with Cfg.TrustProxy set,
+otherwise anyone could pick their own IP by sending the header
+*/
+func GetClientIP(r *http.Request) string {
+	if Cfg.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
 func ParseBoolOrFalse(val string) bool {
 	if val == "true" {
 		return true
@@ -107,21 +318,6 @@ func TruncateFilename(original string, maxBaseLen int) string {
 	return base + ext
 }
 
-func IsAcceptedMIME(file multipart.File, acceptedMIMEs []string) bool {
-	buf := make([]byte, 512)
-	n, _ := file.Read(buf)
-	file.Seek(0, io.SeekStart)
-
-	mime := http.DetectContentType(buf[:n])
-
-	for _, m := range acceptedMIMEs {
-		if mime == m {
-			return true
-		}
-	}
-	return false
-}
-
 func IsAcceptedFileFormat(filename string, acceptedFormats []string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
 	for _, format := range acceptedFormats {
@@ -132,3 +328,26 @@ func IsAcceptedFileFormat(filename string, acceptedFormats []string) bool {
 
 	return false
 }
+
+// removes the file behind an imagepath stored in posts / comments from the storage, see storageController.go
+func RemoveUpload(imagePath string) error {
+	if imagePath == "" {
+		return nil
+	}
+
+	return Uploads.Delete(uploadName(imagePath))
+}
+
+// removes every upload in imagePaths, returning how many could actually be removed
+func RemoveUploads(imagePaths []string) int {
+	removed := 0
+	for _, imagePath := range imagePaths {
+		if err := RemoveUpload(imagePath); err != nil {
+			fmt.Printf("File of %s could not be removed: %v\n", imagePath, err)
+			continue
+		}
+		removed++
+	}
+
+	return removed
+}
@@ -0,0 +1,471 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+/*
+	re-encoding uploaded images before they're stored.
+
+	sniffing the first 512 bytes (detectUploadType()) only tells us what a file starts with, whatever comes
+	after that gets published as is. that's how the GPS coordinates in the EXIF data of phone photos ended
+	up public, and how files that are both a valid image and something else entirely (polyglots) got in.
+
+	so every upload is decoded and encoded again from the pixels alone, which drops everything that isn't
+	part of the image itself: EXIF, XMP, comments, trailing data. anything that doesn't decode isn't an
+	image and gets rejected. animated GIFs keep every frame with its timing, as long as all of their frames
+	together have no more pixels than the largest image that can be uploaded (all of them are decoded at once).
+
+	the one piece of metadata that matters is the EXIF orientation, phones store photos sideways and let
+	the viewer rotate them. since that tag is gone afterwards, JPEGs get rotated the right way up first.
+
+	WebP is the exception, there's no decoder for it in the standard library. those only get their
+	container taken apart and put back together without the EXIF and XMP chunks (CleanWebP()), the
+	image data itself is passed on as is. they don't get thumbnails either
+*/
+
+const reencodeJPEGQuality = 90
+
+var (
+	ErrUndecodableUpload  = errors.New("upload could not be decoded")
+	ErrDimensionsTooLarge = errors.New("upload dimensions are too large")
+	ErrAnimationTooLarge  = errors.New("upload has too many frames")
+)
+
+/*
+whether an image or video of width by height pixels is within Cfg.UploadMaxWidth and Cfg.UploadMaxHeight.
+this is also what keeps decoding from taking more memory than it's worth
+*/
+func withinUploadDimensions(width, height int) bool {
+	return width <= Cfg.UploadMaxWidth && height <= Cfg.UploadMaxHeight
+}
+
+// the pixels of the largest image that can be uploaded, which is also what all frames of a GIF can add up to
+func maxUploadPixels() int64 {
+	return int64(Cfg.UploadMaxWidth) * int64(Cfg.UploadMaxHeight)
+}
+
+/*
+adds up the pixels of every frame of the GIF in src by walking its blocks, without decoding any of
+them. gif.DecodeAll() keeps all the frames in memory at once (a byte per pixel), so this is what decoding
+it would take. ok is false when src isn't laid out like a GIF
+*/
+func gifPixels(src io.Reader) (int64, bool) {
+	r := bufio.NewReader(src)
+
+	// header and logical screen descriptor, followed by the global color table if there is one
+	screen := make([]byte, 13)
+	if _, err := io.ReadFull(r, screen); err != nil {
+		return 0, false
+	}
+	if !skipGIFColorTable(r, screen[10]) {
+		return 0, false
+	}
+
+	var pixels int64
+	for {
+		introducer, err := r.ReadByte()
+		if err != nil {
+			// a missing trailer is fine for the decoder as long as there was a frame
+			return pixels, err == io.EOF && pixels > 0
+		}
+
+		switch introducer {
+		case 0x21: // extension: a label and its data
+			if _, err := r.ReadByte(); err != nil || !skipGIFSubBlocks(r) {
+				return 0, false
+			}
+
+		case 0x2c: // frame: its position and size, color table, LZW code size and the image data
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return 0, false
+			}
+			width := binary.LittleEndian.Uint16(descriptor[4:6])
+			height := binary.LittleEndian.Uint16(descriptor[6:8])
+			pixels += int64(width) * int64(height)
+
+			if !skipGIFColorTable(r, descriptor[8]) {
+				return 0, false
+			}
+			if _, err := r.ReadByte(); err != nil || !skipGIFSubBlocks(r) {
+				return 0, false
+			}
+
+		case 0x3b: // trailer
+			return pixels, true
+
+		default:
+			return 0, false
+		}
+	}
+}
+
+// skips the color table that the flags of a screen or frame descriptor announce, if any
+func skipGIFColorTable(r *bufio.Reader, flags byte) bool {
+	if flags&0x80 == 0 {
+		return true
+	}
+	size := 3 << (flags&0x07 + 1)
+	_, err := r.Discard(size)
+	return err == nil
+}
+
+// skips data sub-blocks (a length byte, then that many bytes) up to the empty one that ends them
+func skipGIFSubBlocks(r *bufio.Reader) bool {
+	for {
+		length, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		if length == 0 {
+			return true
+		}
+		if _, err := r.Discard(int(length)); err != nil {
+			return false
+		}
+	}
+}
+
+/*
+decodes the image in src and encodes it again in the same format without any of its metadata,
+returning the new file contents, the (first frame of the) image for the thumbnail and its format
+*/
+func ReencodeImage(src io.ReadSeeker) ([]byte, image.Image, string, error) {
+	config, format, err := image.DecodeConfig(src)
+	if err != nil {
+		return nil, nil, "", ErrUndecodableUpload
+	}
+	if !withinUploadDimensions(config.Width, config.Height) {
+		return nil, nil, "", ErrDimensionsTooLarge
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, "", err
+	}
+
+	var out bytes.Buffer
+	var img image.Image
+
+	switch format {
+	case "gif":
+		pixels, ok := gifPixels(src)
+		if !ok {
+			return nil, nil, "", ErrUndecodableUpload
+		}
+		if pixels > maxUploadPixels() {
+			return nil, nil, "", ErrAnimationTooLarge
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, "", err
+		}
+
+		animation, err := gif.DecodeAll(src)
+		if err != nil || len(animation.Image) == 0 {
+			return nil, nil, "", ErrUndecodableUpload
+		}
+
+		// only the frames, their timing and the loop count survive this
+		clean := &gif.GIF{
+			Image:           animation.Image,
+			Delay:           animation.Delay,
+			LoopCount:       animation.LoopCount,
+			Disposal:        animation.Disposal,
+			Config:          animation.Config,
+			BackgroundIndex: animation.BackgroundIndex,
+		}
+		if err := gif.EncodeAll(&out, clean); err != nil {
+			return nil, nil, "", err
+		}
+		img = animation.Image[0]
+
+	case "jpeg":
+		orientation := jpegOrientation(src)
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, "", err
+		}
+
+		decoded, err := jpeg.Decode(src)
+		if err != nil {
+			return nil, nil, "", ErrUndecodableUpload
+		}
+
+		img = orientImage(decoded, orientation)
+		if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: reencodeJPEGQuality}); err != nil {
+			return nil, nil, "", err
+		}
+
+	case "png":
+		decoded, err := png.Decode(src)
+		if err != nil {
+			return nil, nil, "", ErrUndecodableUpload
+		}
+
+		img = decoded
+		if err := png.Encode(&out, img); err != nil {
+			return nil, nil, "", err
+		}
+
+	default:
+		return nil, nil, "", ErrUndecodableUpload
+	}
+
+	return out.Bytes(), img, format, nil
+}
+
+/*
+reads the EXIF orientation tag (1-8) of the JPEG in src, 1 (as is) if there's none. only the segments
+before the image data are looked at, that's where EXIF lives
+*/
+func jpegOrientation(src io.Reader) int {
+	header := make([]byte, 64<<10)
+	n, _ := io.ReadFull(src, header)
+	data := header[:n]
+
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			break
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+
+		i += 2 + length
+	}
+
+	return 1
+}
+
+// looks through the first IFD of the TIFF structure inside an EXIF segment for the orientation tag
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < entries; k++ {
+		entry := ifd + 2 + k*12
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+
+	return 1
+}
+
+/*
+turns and / or mirrors img so that it shows the right way up, depending on its EXIF orientation:
+  - 2: mirror, 3: turn upside down, 4: mirror vertically
+  - 5: mirror and turn left, 6: turn right, 7: mirror and turn right, 8: turn left
+*/
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+
+			s := src.PixOffset(x, y)
+			copy(dst.Pix[dst.PixOffset(dx, dy):], src.Pix[s:s+4])
+		}
+	}
+
+	return dst
+}
+
+// chunks that make up the image itself, everything else in a WebP (EXIF, XMP, unknown chunks) is dropped
+var webpImageChunks = map[string]bool{
+	"VP8X": true,
+	"VP8 ": true,
+	"VP8L": true,
+	"ALPH": true,
+	"ICCP": true,
+	"ANIM": true,
+	"ANMF": true,
+}
+
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+/*
+takes the WebP in data apart and puts it back together with only the chunks in webpImageChunks, so
+without any metadata or data trailing after it. its dimensions are checked against the upload limits
+on the way. see https://developers.google.com/speed/webp/docs/riff_container
+*/
+func CleanWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrUndecodableUpload
+	}
+
+	// whatever comes after the size in the RIFF header isn't part of the image
+	riffEnd := 8 + int64(binary.LittleEndian.Uint32(data[4:]))
+	if riffEnd > int64(len(data)) {
+		return nil, ErrUndecodableUpload
+	}
+	data = data[:riffEnd]
+
+	var out bytes.Buffer
+	out.WriteString("RIFF\x00\x00\x00\x00WEBP")
+
+	width, height := 0, 0
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, ErrUndecodableUpload
+		}
+
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if i+8+size > len(data) {
+			return nil, ErrUndecodableUpload
+		}
+		chunk := data[i : i+8+size]
+		payload := chunk[8:]
+		first := i == 12
+
+		// chunks are padded to an even size, the padding byte isn't counted in the size
+		next := i + 8 + size + size%2
+		i = min(next, len(data))
+
+		// the first chunk decides what kind of WebP it is, and that's where its dimensions are
+		if first {
+			var ok bool
+			width, height, ok = webpDimensions(fourCC, payload)
+			if !ok {
+				return nil, ErrUndecodableUpload
+			}
+		} else if fourCC == "VP8X" {
+			// the extended header only ever comes first (where its size was checked), never again
+			return nil, ErrUndecodableUpload
+		}
+
+		if !webpImageChunks[fourCC] {
+			continue
+		}
+
+		start := out.Len()
+		out.Write(chunk)
+		if size%2 == 1 {
+			out.WriteByte(0)
+		}
+
+		// the extended header has to stop announcing the metadata that's gone now
+		if fourCC == "VP8X" {
+			out.Bytes()[start+8] &^= webpFlagEXIF | webpFlagXMP
+		}
+	}
+
+	if out.Len() == 12 {
+		return nil, ErrUndecodableUpload
+	}
+	if !withinUploadDimensions(width, height) {
+		return nil, ErrDimensionsTooLarge
+	}
+
+	cleaned := out.Bytes()
+	binary.LittleEndian.PutUint32(cleaned[4:], uint32(len(cleaned)-8))
+	return cleaned, nil
+}
+
+// reads the dimensions of a WebP out of its first chunk, which is either of the image data or the extended header
+func webpDimensions(fourCC string, payload []byte) (int, int, bool) {
+	switch fourCC {
+	case "VP8X":
+		if len(payload) < 10 {
+			return 0, 0, false
+		}
+		width := int(payload[4]) | int(payload[5])<<8 | int(payload[6])<<16
+		height := int(payload[7]) | int(payload[8])<<8 | int(payload[9])<<16
+		return width + 1, height + 1, true
+
+	case "VP8 ":
+		// frame tag, then the start code of a key frame, then 14 bits each of width and height
+		if len(payload) < 10 || payload[0]&0x01 != 0 || !bytes.Equal(payload[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, false
+		}
+		width := int(binary.LittleEndian.Uint16(payload[6:]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(payload[8:]) & 0x3fff)
+		return width, height, width > 0 && height > 0
+
+	case "VP8L":
+		// signature byte, then 14 bits each of width - 1 and height - 1
+		if len(payload) < 5 || payload[0] != 0x2f {
+			return 0, 0, false
+		}
+		bits := binary.LittleEndian.Uint32(payload[1:])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, true
+	}
+
+	return 0, 0, false
+}
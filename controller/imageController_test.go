@@ -0,0 +1,314 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{0xff, 0, 0, 0xff}
+	blue = color.RGBA{0, 0, 0xff, 0xff}
+)
+
+// an image with a red left half and a blue right half
+func redBlueImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.SetRGBA(x, y, red)
+			} else {
+				img.SetRGBA(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+/*
+the TIFF structure of an EXIF segment like phones write it: the orientation in the first IFD and a
+GPS IFD with the latitude (52°31'N) the photo was taken at
+*/
+func exifWithGPS(orientation uint16) []byte {
+	var tiff bytes.Buffer
+	le := binary.LittleEndian
+	write := func(v any) { binary.Write(&tiff, le, v) }
+
+	tiff.WriteString("II")
+	write(uint16(42))
+	write(uint32(8))
+
+	// IFD0 at 8: orientation and the offset of the GPS IFD
+	write(uint16(2))
+	write([]uint16{0x0112, 3})
+	write(uint32(1))
+	write([]uint16{orientation, 0})
+	write([]uint16{0x8825, 4})
+	write(uint32(1))
+	write(uint32(38))
+	write(uint32(0))
+
+	// GPS IFD at 38: latitude reference and the latitude itself, as three rationals at 68
+	write(uint16(2))
+	write([]uint16{0x0001, 2})
+	write(uint32(2))
+	tiff.WriteString("N\x00\x00\x00")
+	write([]uint16{0x0002, 5})
+	write(uint32(3))
+	write(uint32(68))
+	write(uint32(0))
+	write([]uint32{52, 1, 31, 1, 0, 1})
+
+	return append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+}
+
+// encodes img as a JPEG with exif in an APP1 segment right after the start of the image
+func jpegWithEXIF(t *testing.T, img image.Image, exif []byte) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	segment = append(segment, exif...)
+
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+// the markers of the segments in front of the image data of a JPEG
+func jpegMarkers(data []byte) []byte {
+	var markers []byte
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF && data[i+1] != 0xDA; {
+		markers = append(markers, data[i+1])
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+	}
+	return markers
+}
+
+// whether c is close to want, JPEG doesn't keep colors exactly
+func nearColor(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	near := func(got uint32, want uint8) bool {
+		diff := int(got>>8) - int(want)
+		return diff > -48 && diff < 48
+	}
+	return near(r, want.R) && near(g, want.G) && near(b, want.B)
+}
+
+func TestReencodeJPEGDropsEXIF(t *testing.T) {
+	LoadConfig()
+
+	original := jpegWithEXIF(t, redBlueImage(40, 20), exifWithGPS(1))
+	if !bytes.Contains(original, []byte("Exif")) {
+		t.Fatal("fixture has no EXIF to begin with")
+	}
+
+	out, _, format, err := ReencodeImage(bytes.NewReader(original))
+	if err != nil || format != "jpeg" {
+		t.Fatalf("ReencodeImage() = %q, %v", format, err)
+	}
+
+	if bytes.IndexByte(jpegMarkers(out), 0xE1) >= 0 {
+		t.Errorf("re-encoded JPEG still has an APP1 segment (markers %x)", jpegMarkers(out))
+	}
+	if bytes.Contains(out, []byte("Exif")) {
+		t.Error("re-encoded JPEG still has EXIF data")
+	}
+}
+
+func TestReencodeJPEGOrientation(t *testing.T) {
+	LoadConfig()
+
+	// turned right: the left (red) half ends up on top, the right (blue) one at the bottom
+	original := jpegWithEXIF(t, redBlueImage(40, 20), exifWithGPS(6))
+
+	out, img, _, err := ReencodeImage(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40 {
+		t.Fatalf("oriented image is %v, want 20x40", img.Bounds())
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds().Dx() != 20 || decoded.Bounds().Dy() != 40 {
+		t.Fatalf("re-encoded JPEG is %v, want 20x40", decoded.Bounds())
+	}
+	if !nearColor(decoded.At(10, 5), red) || !nearColor(decoded.At(10, 34), blue) {
+		t.Errorf("re-encoded JPEG isn't turned right: %v on top, %v at the bottom", decoded.At(10, 5), decoded.At(10, 34))
+	}
+}
+
+// an animated GIF with frames frames of width by height pixels
+func animatedGIF(t *testing.T, frames, width, height int) []byte {
+	t.Helper()
+
+	animation := &gif.GIF{LoopCount: 0}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		for x := 0; x < width; x++ {
+			frame.Set(x, i%height, red)
+		}
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10*(i+1))
+	}
+
+	var encoded bytes.Buffer
+	if err := gif.EncodeAll(&encoded, animation); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+func TestReencodeGIFKeepsFrames(t *testing.T) {
+	LoadConfig()
+
+	out, img, format, err := ReencodeImage(bytes.NewReader(animatedGIF(t, 5, 32, 16)))
+	if err != nil || format != "gif" {
+		t.Fatalf("ReencodeImage() = %q, %v", format, err)
+	}
+	if img.Bounds().Dx() != 32 || img.Bounds().Dy() != 16 {
+		t.Errorf("first frame is %v", img.Bounds())
+	}
+
+	animation, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(animation.Image) != 5 {
+		t.Fatalf("re-encoded GIF has %d frames, want 5", len(animation.Image))
+	}
+	for i, delay := range animation.Delay {
+		if delay != 10*(i+1) {
+			t.Errorf("frame %d has a delay of %d, want %d", i, delay, 10*(i+1))
+		}
+	}
+}
+
+// all frames together can't have more pixels than the largest image
+func TestReencodeGIFPixelBudget(t *testing.T) {
+	LoadConfig()
+	Cfg.UploadMaxWidth, Cfg.UploadMaxHeight = 64, 64
+
+	if _, _, _, err := ReencodeImage(bytes.NewReader(animatedGIF(t, 4, 32, 32))); err != nil {
+		t.Fatalf("GIF right at the budget: %v", err)
+	}
+
+	_, _, _, err := ReencodeImage(bytes.NewReader(animatedGIF(t, 5, 32, 32)))
+	if !errors.Is(err, ErrAnimationTooLarge) {
+		t.Fatalf("GIF over the budget: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	WriteUploadError(rec, err)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("WriteUploadError() = %d", rec.Code)
+	}
+}
+
+// something that starts out like a PNG but isn't one gets turned away with a 415
+func TestUploadUndecodableImage(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("postcontent", "look at this")
+	file, err := form.CreateFormFile("image", "broken.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR this is not an image at all"))
+	form.Close()
+
+	rec := httptest.NewRecorder()
+	AddPost(rec, requestAs(t, "alice", http.MethodPost, "/api/addPost", &body, form.FormDataContentType()))
+	if rec.Code != http.StatusUnsupportedMediaType || !strings.Contains(rec.Body.String(), "could not be read") {
+		t.Fatalf("AddPost() with an undecodable image = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var posts int
+	db.QueryRow(`SELECT COUNT(*) FROM posts`).Scan(&posts)
+	if posts != 0 {
+		t.Fatalf("%d post(s) were added anyway", posts)
+	}
+}
+
+// a RIFF WebP container around chunks, each given as its FourCC and payload
+func webpFile(chunks ...string) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for i := 0; i+1 < len(chunks); i += 2 {
+		body.WriteString(chunks[i])
+		binary.Write(&body, binary.LittleEndian, uint32(len(chunks[i+1])))
+		body.WriteString(chunks[i+1])
+		if len(chunks[i+1])%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	header := []byte("RIFF\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint32(header[4:], uint32(body.Len()))
+	return append(header, body.Bytes()...)
+}
+
+// extended header announcing EXIF for a 16x8 image, followed by the (lossless) image itself
+const (
+	webpVP8X = "\x08\x00\x00\x00\x0f\x00\x00\x07\x00\x00"
+	webpVP8L = "\x2f\x0f\xc0\x01\x00\x00"
+)
+
+func TestCleanWebPDropsEXIF(t *testing.T) {
+	LoadConfig()
+
+	cleaned, err := CleanWebP(webpFile("VP8X", webpVP8X, "EXIF", "GPS somewhere", "VP8L", webpVP8L))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := webpFile("VP8X", "\x00"+webpVP8X[1:], "VP8L", webpVP8L)
+	if !bytes.Equal(cleaned, want) {
+		t.Fatalf("CleanWebP() =\n%q\nwant\n%q", cleaned, want)
+	}
+}
+
+// a second, empty extended header used to index past the end of the chunk
+func TestCleanWebPTruncatedVP8X(t *testing.T) {
+	LoadConfig()
+
+	for name, data := range map[string][]byte{
+		"second VP8X":           webpFile("VP8X", webpVP8X, "VP8X", "", "VP8L", webpVP8L),
+		"second VP8X, sized":    webpFile("VP8L", webpVP8L, "VP8X", webpVP8X),
+		"first VP8X, too short": webpFile("VP8X", "\x08\x00", "VP8L", webpVP8L),
+	} {
+		_, err := CleanWebP(data)
+		if !errors.Is(err, ErrUndecodableUpload) {
+			t.Errorf("%s: CleanWebP() = %v", name, err)
+			continue
+		}
+
+		rec := httptest.NewRecorder()
+		WriteUploadError(rec, err)
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("%s: WriteUploadError() = %d", name, rec.Code)
+		}
+	}
+}
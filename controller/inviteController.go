@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+	invite codes for gated registration: admins mint codes from the dashboard which can then be redeemed
+	through Register() whenever Cfg.RegistrationMode is "invite" (or optionally in "open" mode, to get a
+	preset role).
+
+	a code can be single-use or N-use, can expire and can hand out a preset role to whoever redeems it.
+	every redemption is kept in "invite_redemptions" so the dashboard can show who used which code
+*/
+
+var ErrInvalidInvite = errors.New("invite code is invalid, expired or used up")
+
+/*
+struct for invite-related data that we can assemble and serve
+  - Code: the invite code itself, what the person registering has to enter
+  - Role: role handed out to accounts registering with the code
+  - MaxUses: how many times the code can be redeemed
+  - Uses: how many times the code has been redeemed already
+  - ExpiresInHours: only used when creating, how long until the code expires (0 for never)
+  - ExpiresAt: timestamp of when the code expires, empty for never
+  - CreatedBy: username of the admin that created the code
+  - CreatedAt: timestamp of when the code was created
+  - RedeemedBy: usernames of everyone that redeemed the code
+*/
+type InviteData struct {
+	Code           string   `json:"code"`
+	Role           string   `json:"role"`
+	MaxUses        int      `json:"maxuses"`
+	Uses           int      `json:"uses"`
+	ExpiresInHours int      `json:"expiresinhours,omitempty"`
+	ExpiresAt      string   `json:"expiresat"`
+	CreatedBy      string   `json:"createdby"`
+	CreatedAt      string   `json:"createdat"`
+	RedeemedBy     []string `json:"redeemedby"`
+}
+
+func CreateInvite(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch in CreateInvite, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to create invites!")
+		return
+	}
+
+	var data InviteData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if data.MaxUses < 1 {
+		data.MaxUses = 1
+	}
+
+	if data.Role == "" {
+		data.Role = RoleUser
+	}
+	if !RoleExists(data.Role) {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid role for invite")
+		return
+	}
+
+	var expiresAt sql.NullInt64
+	if data.ExpiresInHours > 0 {
+		expiresAt.Int64 = time.Now().Add(time.Duration(data.ExpiresInHours) * time.Hour).Unix()
+		expiresAt.Valid = true
+	}
+
+	data.Code, err = GenerateRandomHex(8)
+	if err != nil {
+		log.Printf("Error generating invite code: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	currentUsername := GetUsernameFromCookie(r, "userSessionToken")
+	err = WriteToSQL(`
+		INSERT INTO invites (code, role, max_uses, expires_at, created_by)
+		VALUES (?, ?, ?, ?, ?)
+	`, data.Code, data.Role, data.MaxUses, expiresAt, currentUsername)
+	if err != nil {
+		log.Printf("Error inserting invite: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	fmt.Printf("Invite %s created by %s (role %s, %d use(s))\n", data.Code, currentUsername, data.Role, data.MaxUses)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+		"code":   data.Code,
+	})
+}
+
+func DeleteInvite(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch in DeleteInvite, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to delete invites!")
+		return
+	}
+
+	var data InviteData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// redemptions are kept around on purpose, so we still know where an account came from
+	WriteToSQL(`DELETE FROM invites WHERE code = ?`, data.Code)
+	fmt.Printf("Invite %s deleted successfully\n", data.Code)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+	})
+}
+
+func RequestInvites(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch in RequestInvites, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to view invites!")
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT code, role, max_uses, uses, expires_at, created_by, created_at
+		FROM invites
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		log.Printf("Error querying invites: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer rows.Close()
+
+	invites := []InviteData{}
+	indexByCode := make(map[string]int)
+	for rows.Next() {
+		var invite InviteData
+		var expiresAt sql.NullInt64
+
+		err := rows.Scan(
+			&invite.Code,
+			&invite.Role,
+			&invite.MaxUses,
+			&invite.Uses,
+			&expiresAt,
+			&invite.CreatedBy,
+			&invite.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Error scanning invite: %v\n", err)
+			continue
+		}
+
+		if expiresAt.Valid {
+			invite.ExpiresAt = time.Unix(expiresAt.Int64, 0).UTC().Format(time.RFC3339)
+		}
+		invite.RedeemedBy = []string{}
+
+		indexByCode[invite.Code] = len(invites)
+		invites = append(invites, invite)
+	}
+
+	redemptions, err := db.Query(`SELECT code, username FROM invite_redemptions ORDER BY redeemed_at`)
+	if err != nil {
+		log.Printf("Error querying invite redemptions: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer redemptions.Close()
+
+	for redemptions.Next() {
+		var code, username string
+		if err := redemptions.Scan(&code, &username); err != nil {
+			log.Printf("Error scanning invite redemption: %v\n", err)
+			continue
+		}
+
+		if i, ok := indexByCode[code]; ok {
+			invites[i].RedeemedBy = append(invites[i].RedeemedBy, username)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+/*
+redeems an invite code for username inside of tx, returning the role the code hands out.
+
+the use counter is only incremented when the code still has uses left and hasn't expired, so two
+people racing for the last use of a code can't both get it
+*/
+func RedeemInvite(tx *sql.Tx, code, username string) (string, error) {
+	res, err := tx.Exec(`
+		UPDATE invites SET uses = uses + 1
+		WHERE code = ? AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)
+	`, code, time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if affected == 0 {
+		return "", ErrInvalidInvite
+	}
+
+	var role string
+	err = tx.QueryRow(`SELECT role FROM invites WHERE code = ?`, code).Scan(&role)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO invite_redemptions (code, username)
+		VALUES (?, ?)
+	`, code, username)
+	if err != nil {
+		return "", err
+	}
+
+	return role, nil
+}
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	versioned schema migrations for mmiv.db: every file in migrations/ is named "<version>_<name>.sql",
+	embedded into the binary and applied in order of its version at startup.
+
+	the applied versions are kept in the "schema_version" table, so each migration only runs once per
+	database and adding a column to an existing mmiv.db is just a matter of adding a new file. each
+	migration runs inside of its own transaction, if one fails nothing of it is applied and startup stops
+
+	NOTE: never edit a migration that has already been released, add a new one instead
+*/
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+/*
+struct for a single migration
+  - Version: ordered version number, taken from the filename prefix
+  - Name: rest of the filename, describing what the migration does
+  - SQL: the statements to run
+*/
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func LoadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		versionStr, name, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.sql", fileName)
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version: %w", fileName, err)
+		}
+
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, fileName, version)
+		}
+		seen[version] = fileName
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			SQL:     string(content),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// returns the version of the last applied migration, 0 for a database that was never migrated
+func CurrentSchemaVersion() (int, error) {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version')
+	`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+func PendingMigrations() ([]Migration, error) {
+	current, err := CurrentSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.Version > current {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// applies every pending migration in order, each in its own transaction
+func MigrateSQL() error {
+	err := WriteToSQL(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	pending, err := PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migration.SQL)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO schema_version (version, name)
+			VALUES (?, ?)
+		`, migration.Version, migration.Name)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+
+		fmt.Printf("Applied migration %04d_%s\n", migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+func PrintPendingMigrations() error {
+	current, err := CurrentSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	pending, err := PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Current schema version: %d\n", current)
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations")
+		return nil
+	}
+
+	fmt.Printf("%d pending migration(s):\n", len(pending))
+	for _, migration := range pending {
+		fmt.Printf("  %04d_%s\n", migration.Version, migration.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+-- the schema as it was before migrations existed, IF NOT EXISTS keeps it safe on older databases
+
+CREATE TABLE IF NOT EXISTS posts (
+	id INTEGER PRIMARY KEY,
+	username TEXT NOT NULL,
+	postcontent TEXT NOT NULL,
+	imagepath TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	pinned INTEGER NOT NULL DEFAULT 0,
+	locked INTEGER NOT NULL DEFAULT 0,
+	isanonymous INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	password TEXT NOT NULL,
+	rank INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id INTEGER PRIMARY KEY,
+	parentpostid INTEGER NOT NULL,
+	username TEXT NOT NULL,
+	postcontent TEXT NOT NULL,
+	imagepath TEXT NOT NULL,
+	timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+	isanonymous INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS announcements (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	content TEXT NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS emoticons (
+	name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS global_ids (
+	id INTEGER PRIMARY KEY AUTOINCREMENT
+);
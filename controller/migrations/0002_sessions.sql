@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	token TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	expiry INTEGER NOT NULL
+);
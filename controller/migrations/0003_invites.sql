@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS invites (
+	code TEXT PRIMARY KEY,
+	rank INTEGER NOT NULL DEFAULT 1,
+	max_uses INTEGER NOT NULL DEFAULT 1,
+	uses INTEGER NOT NULL DEFAULT 0,
+	expires_at INTEGER,
+	created_by TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS invite_redemptions (
+	code TEXT NOT NULL,
+	username TEXT NOT NULL,
+	redeemed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+-- set whenever an admin resets a password, the user has to pick a new one before logging in
+ALTER TABLE users ADD COLUMN must_change_password INTEGER NOT NULL DEFAULT 0;
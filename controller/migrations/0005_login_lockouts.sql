@@ -0,0 +1,9 @@
+-- every lockout from the login throttle, kept for admins to review on the dashboard
+CREATE TABLE IF NOT EXISTS login_lockouts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	failures INTEGER NOT NULL,
+	locked_until INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
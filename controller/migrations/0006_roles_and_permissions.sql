@@ -0,0 +1,48 @@
+-- named roles with fine-grained permissions, replacing the numeric ranks ("1" user, "2" admin)
+
+CREATE TABLE IF NOT EXISTS roles (
+	name TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role TEXT NOT NULL,
+	permission TEXT NOT NULL,
+	PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name) VALUES ('user'), ('moderator'), ('admin');
+
+INSERT INTO role_permissions (role, permission) VALUES
+	('user', 'read'),
+	('user', 'post'),
+	('user', 'comment'),
+
+	('moderator', 'read'),
+	('moderator', 'post'),
+	('moderator', 'comment'),
+	('moderator', 'pin'),
+	('moderator', 'lock'),
+	('moderator', 'delete-any'),
+	('moderator', 'view-anonymous'),
+	('moderator', 'view-dashboard'),
+
+	('admin', 'read'),
+	('admin', 'post'),
+	('admin', 'comment'),
+	('admin', 'post-html'),
+	('admin', 'pin'),
+	('admin', 'lock'),
+	('admin', 'delete-any'),
+	('admin', 'view-anonymous'),
+	('admin', 'view-dashboard'),
+	('admin', 'manage-users'),
+	('admin', 'manage-emoticons'),
+	('admin', 'manage-announcements');
+
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+UPDATE users SET role = CASE WHEN rank >= 2 THEN 'admin' ELSE 'user' END;
+ALTER TABLE users DROP COLUMN rank;
+
+ALTER TABLE invites ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+UPDATE invites SET role = CASE WHEN rank >= 2 THEN 'admin' ELSE 'user' END;
+ALTER TABLE invites DROP COLUMN rank;
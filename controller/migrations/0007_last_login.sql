@@ -0,0 +1,2 @@
+-- when a user last logged in, shown in the user listing of the dashboard (NULL for never)
+ALTER TABLE users ADD COLUMN last_login DATETIME;
@@ -0,0 +1,18 @@
+-- permanent (expires_at NULL) or timed bans, read_only bans still allow logging in and reading
+CREATE TABLE IF NOT EXISTS bans (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	read_only INTEGER NOT NULL DEFAULT 0,
+	expires_at INTEGER,
+	created_by TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	lifted_by TEXT,
+	lifted_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS bans_username ON bans (username);
+
+INSERT INTO role_permissions (role, permission) VALUES
+	('moderator', 'ban-users'),
+	('admin', 'ban-users');
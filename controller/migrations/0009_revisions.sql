@@ -0,0 +1,16 @@
+-- post and comment editing: when something was last edited, and every version it replaced
+ALTER TABLE posts ADD COLUMN edited_at DATETIME;
+ALTER TABLE comments ADD COLUMN edited_at DATETIME;
+
+CREATE TABLE IF NOT EXISTS revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	targetid INTEGER NOT NULL,
+	postcontent TEXT NOT NULL,
+	edited_by TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS revisions_target ON revisions (kind, targetid);
+
+INSERT INTO role_permissions (role, permission) VALUES ('admin', 'edit-any');
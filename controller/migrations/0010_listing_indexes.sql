@@ -0,0 +1,5 @@
+-- comments are always looked up (and counted) through their parent post
+CREATE INDEX IF NOT EXISTS comments_parentpostid ON comments (parentpostid);
+
+-- the order (and cursor) RequestPost() pages through
+CREATE INDEX IF NOT EXISTS posts_pinned_id ON posts (pinned, id);
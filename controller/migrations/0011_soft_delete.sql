@@ -0,0 +1,5 @@
+-- deleted posts and comments go to the trash first and are only purged after Cfg.TrashRetention
+ALTER TABLE posts ADD COLUMN deleted_at DATETIME;
+ALTER TABLE posts ADD COLUMN deleted_by TEXT;
+ALTER TABLE comments ADD COLUMN deleted_at DATETIME;
+ALTER TABLE comments ADD COLUMN deleted_by TEXT;
@@ -0,0 +1,3 @@
+-- downscaled copies of uploaded images for the feed, empty when the original is small enough to show as is
+ALTER TABLE posts ADD COLUMN thumbpath TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN thumbpath TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- uploads are stored under the SHA-256 of their contents, this counts how many posts and comments point at each file
+CREATE TABLE IF NOT EXISTS uploads (
+	path TEXT PRIMARY KEY,
+	refcount INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- usernames are unique regardless of case. this fails (and stops startup) while duplicates exist, those
+-- have to be renamed or deleted by hand first, OpenSQL() lists them
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_unique ON users (username COLLATE NOCASE);
@@ -0,0 +1,4 @@
+-- whether postcontent was stored as raw HTML (reject-sanitize) instead of escaped, so editing can hand
+-- back the text as it was typed. older rows can't tell and count as escaped
+ALTER TABLE posts ADD COLUMN rawhtml INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN rawhtml INTEGER NOT NULL DEFAULT 0;
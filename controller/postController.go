@@ -5,13 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"html"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,7 +25,7 @@ import (
 	feature but right now I highly doubt it
 
 	ALSO, there should probably be a check from the back-end to the front-end that cuts out anything the
-	"user" ( rank 1 ) shouldn't need vs "admin" ( rank 2 ) needs, to avoid unnecessary data being sent &
+	"user" role shouldn't need vs "admin" role needs, to avoid unnecessary data being sent &
 	also to avoid people from snooping variables and potentially exploiting vulnerabilities because they
 	know what the back-end has
 
@@ -35,46 +33,29 @@ import (
 	and other stuff that may degrade the quality of the platform in some way
 
 	TODO:
-		* page system for posts (start from nr. / cont. from nr.)
-		* thumbnail compression for posts (?)
 		* "message too long, click here to expand" feature for long posts
 		* message length limit (250 characters maybe) (?)
 		* hide post / comment
 
-	FIXME / BUGS:
-		* deletion of posts causes a display desync for some reason
-		reproduction steps:
-			* create a post of A
-			* delete a post of A
-			* create a post of B
-			* appears post A
-			* create a post of C
-			* appears post B...
-
-		caused by pagination with desync: offset specifically
+	NOTE: deleting posts used to cause a display desync (posts skipped or shown twice) because paging
+	went through LIMIT / OFFSET, which shifts whenever posts are created or deleted in between two
+	pages. RequestPost() now takes a "cursor" (the (pinned, id) of the last post seen) and continues
+	right after it instead, the old offset parameters are only kept around for older clients
 
+	FIXME / BUGS:
 		clicking on a post with a link both opens the new tab and also the post itself for comments
 
 		link support for unsanitized post (regex messes it up)
 
 */
 
-var acceptedExts = []string{
-	".jpg", ".jpeg", ".png", ".gif",
-}
-
-var acceptedMIMEs = []string{
-	"image/jpeg",
-	"image/png",
-	"image/gif",
-}
-
 /*
 struct for post-related data that we can assemble and serve
   - ID: ID of the post in the database, front-end displayed numerically as well
   - Username: Username of the person that created the post
   - PostContent: text string accompanied by the post
   - Imagepath: local machine path to the image that's being stored
+  - Thumbpath: local machine path to the downscaled copy of the image, empty if it has none
   - Timestamp: timestamp of when the post was submitted
   - CommentCount: how many children comments the post has
   - Pinned: whether post is pinned by someone with escalated privileges (shows up top)
@@ -82,12 +63,17 @@ struct for post-related data that we can assemble and serve
   - CanPin: back-end variable for when administrators are querying a post and should have the option of pinning available
   - CanLock: back-end variable for when administrators are querying a post and should have the option of locking available
   - HasOwnership: back-end variable for when a person has "ownership" of a post
+  - EditedAt: timestamp of when the post was last edited, empty if never
+  - CanEdit: back-end variable for when a person is allowed to edit the post (see revisionController.go)
+  - Source: the text as it was typed (before emoticons, links and escaping) to edit, only with CanEdit
+  - RawHTML: whether the post was submitted as raw HTML, so edits keep it that way, only with CanEdit
 */
 type PostData struct {
 	Id           string `json:"id"`
 	Username     string `json:"username"`
 	PostContent  string `json:"postcontent"`
 	Imagepath    string `json:"imagepath"`
+	Thumbpath    string `json:"thumbpath"`
 	Timestamp    string `json:"timestamp"`
 	CommentCount string `json:"commentcount"`
 	Pinned       bool   `json:"pinned"`
@@ -95,8 +81,25 @@ type PostData struct {
 	CanPin       *bool  `json:"canpin,omitempty"`
 	CanLock      *bool  `json:"canlock,omitempty"`
 	HasOwnership *bool  `json:"hasownership,omitempty"`
+	EditedAt     string `json:"editedat,omitempty"`
+	CanEdit      *bool  `json:"canedit,omitempty"`
+	Source       string `json:"source,omitempty"`
+	RawHTML      bool   `json:"rawhtml,omitempty"`
+}
+
+/*
+struct for a page of posts when RequestPost() is asked for one through a cursor
+  - Posts: the posts on this page
+  - NextCursor: cursor to pass along to get the page after this one, empty when there are no more posts
+*/
+type PostPage struct {
+	Posts      []PostData `json:"posts"`
+	NextCursor string     `json:"next_cursor"`
 }
 
+// upper limit of how many posts RequestPost() hands out at once
+const maxPostsPerRequest = 100
+
 type PostRequest struct {
 	DisplayFromPostNumber int `json:"displayfrompostnumber"`
 	AmountOfPostsRequired int `json:"amountofpostsrequired"`
@@ -107,8 +110,8 @@ adding posts, AddPost() function does the following outlined:
 
   - checks whether the user is properly authenticated as a valid "account" member
 
-    if not above rank "1", return invalid permission and no permission error. they're
-    not allowed to post because they don't have a valid account they're logged into
+    if they don't have the "post" permission, return invalid permission and no permission error.
+    they're not allowed to post because they don't have a valid account they're logged into
 
   - parse the form given for adding a post and acquire the variables, this includes
     retrieving the data for and assembling the PostData struct as follows:
@@ -130,59 +133,38 @@ adding posts, AddPost() function does the following outlined:
     if file does not constitute as a "valid" format from a predetermined list we return false,
     cancel adding a post and display an error message for the front-end letting them know
 
-  - upload the file with an unique timestamp on the machine to /uploads directory
+  - upload the file on the machine to /uploads directory (see SaveUpload())
 
-    timestamp is added to avoid any duplicate names and to trace when it was uploaded
+    it's named after the SHA-256 of its contents so identical images are only stored once, a
+    downscaled thumbnail for the feed is stored right next to it
 
   - check for any secondary variables (if the post has been requested to be locked, pinned, anonymized)
 
     and of course, default any variables to a default state whenever a person that should have
     no permissions attempts to do administrator actions like locking or pinning
 
-  - finally run WriteToSQL(), feeding in id, currentUsername, postContent, imagePath, locked, pinned, isAnonymous
+  - finally run WriteToSQL(), feeding in id, currentUsername, postContent, imagePath, thumbPath, locked, pinned, isAnonymous
 
     ...and then return success to the front-end
 */
 func AddPost(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "1") {
-		fmt.Printf("Rank mismatch in AddPost, invalid perms!\n")
+	if !HasPermission(r, PermPost) {
+		fmt.Printf("Permission mismatch in AddPost, invalid perms!\n")
+		if ban, banned := GetActiveBan(GetUsernameFromCookie(r, "userSessionToken")); banned {
+			WriteJSONError(w, http.StatusForbidden, ban.Message())
+			return
+		}
 		http.Error(w, "No permission to upload post!", http.StatusForbidden)
 		return
 	}
 
-	err := r.ParseMultipartForm(10 << 20)
-	if err != nil {
-		log.Fatal(err)
+	if !ParseUploadForm(w, r) {
 		return
 	}
 
-	var imagePath string
-	file, handler, err := r.FormFile("image")
-
-	if err == nil {
-		defer file.Close()
-
-		if !IsAcceptedFileFormat(handler.Filename, acceptedExts) || !IsAcceptedMIME(file, acceptedMIMEs) {
-			http.Error(w, "Unsupported file format!", http.StatusUnsupportedMediaType)
-			return
-		}
-
-		uniqueName := fmt.Sprintf("%d_%s", time.Now().UnixNano(), handler.Filename)
-		imagePath = filepath.Join("uploads", uniqueName)
-		dst, err := os.Create(imagePath)
-		if err != nil {
-			log.Fatal(err)
-			return
-		}
-		defer dst.Close()
-
-		_, err = io.Copy(dst, file)
-		if err != nil {
-			log.Fatal(err)
-			return
-		}
-	} else if err != http.ErrMissingFile {
-		log.Fatal(err)
+	imagePath, thumbPath, err := SaveUpload(r)
+	if err != nil {
+		WriteUploadError(w, err)
 		return
 	}
 
@@ -195,25 +177,31 @@ func AddPost(w http.ResponseWriter, r *http.Request) {
 
 	// check whether we should sanitize or not (useful if moderators want better control with injecting HTML)
 	var shouldBeDesanitized = ParseBoolOrFalse(r.FormValue("reject-sanitize"))
-	if !(DoesUserMatchRank(r, "2") && shouldBeDesanitized) {
+	rawHTML := HasPermission(r, PermPostHTML) && shouldBeDesanitized
+	if !rawHTML {
 		fmt.Println("Sanitizing post content to avoid malicious actions...")
 		postContent = html.EscapeString(postContent)
 	}
 
 	// check for locking and pinning, whether user has auth to do it and default to false if not
 	var locked, pinned bool
-	if DoesUserMatchRank(r, "2") {
+	if HasPermission(r, PermLock) {
 		locked = ParseBoolOrFalse(r.FormValue("locked"))
+	}
+	if HasPermission(r, PermPin) {
 		pinned = ParseBoolOrFalse(r.FormValue("pinned"))
-	} else {
-		locked = false
-		pinned = false
 	}
 
-	WriteToSQL(`
-		INSERT INTO POSTS (id, username, postcontent, imagepath, locked, pinned, isanonymous)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, id, currentUsername, postContent, imagePath, locked, pinned, isAnonymous)
+	err = WriteToSQL(`
+		INSERT INTO POSTS (id, username, postcontent, rawhtml, imagepath, thumbpath, locked, pinned, isanonymous)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, currentUsername, postContent, rawHTML, imagePath, thumbPath, locked, pinned, isAnonymous)
+	if err != nil {
+		log.Printf("Error inserting post: %v\n", err)
+		ReleaseUploads(imagePath, thumbPath)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -229,56 +217,47 @@ refer to comment about addPost() function, generally the same vibe except revers
   - check if post is either owned by the user or delete is requested by administrator,
     if neither are valid then return due to invalid permissions
 
-  - get the image path of the post and delete it, just to avoid unnecessary storage of
-    files we no longer want
+  - move the post to the trash by setting deleted_at, which hides it (and with it every comment under
+    it) from everyone. nothing is gone for good yet, so a misclick can still be restored from the
+    trash on the dashboard
 
-  - delete from database using the ID we acquired from the request
+  - the post, its comments and their images are only purged for good once they've been in the trash
+    for longer than Cfg.TrashRetention (see trashController.go)
 */
 func DeletePost(w http.ResponseWriter, r *http.Request) {
 	var data PostData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		log.Fatal(err)
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
 	}
 
 	currentUsername := GetUsernameFromCookie(r, "userSessionToken")
-	postOwner, err := QueryFromSQL(`SELECT username FROM posts WHERE id = ?`, data.Id)
+	postOwner, err := QueryFromSQL(`SELECT username FROM posts WHERE id = ? AND deleted_at IS NULL`, data.Id)
 	if err != nil {
 		fmt.Println("Warning: Can't get post owner! Invalidating delete...")
+		WriteJSONError(w, http.StatusNotFound, "Post does not exist")
 		return
 	}
 
-	if !DoesUserMatchRank(r, "2") {
+	if !HasPermission(r, PermDeleteAny) {
 		if currentUsername != postOwner {
 			fmt.Println("DeletePost request discarded due to invalid perms")
+			WriteJSONError(w, http.StatusForbidden, "No permission to delete post!")
 			return
 		}
 	}
 
-	postImagePath, err := QueryFromSQL(`SELECT imagepath FROM posts WHERE id = ?`, data.Id)
+	err = WriteToSQL(`
+		UPDATE posts SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? WHERE id = ?
+	`, currentUsername, data.Id)
 	if err != nil {
-		fmt.Println("Warning: Can't get getting postImagePath! Defaulting to empty...", err)
-		postImagePath = ""
-	}
-
-	if postImagePath != "" {
-		cwd, err := os.Getwd()
-		if err != nil {
-			log.Fatal(err)
-			return
-		}
-
-		joinedImagePath := filepath.Join(cwd, postImagePath)
-		fmt.Println(joinedImagePath)
-		err = os.Remove(joinedImagePath)
-
-		if err != nil {
-			fmt.Printf("File of %s was not found...\n", joinedImagePath)
-		}
+		log.Printf("Error deleting post ID %s: %v\n", data.Id, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
 	}
 
-	WriteToSQL(`DELETE FROM posts WHERE id = ?`, data.Id)
-	fmt.Printf("Post ID %s deleted successfully\n", data.Id)
+	fmt.Printf("Post ID %s moved to the trash by %s\n", data.Id, currentUsername)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -286,15 +265,60 @@ func DeletePost(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+/*
+deletes a post with every comment under it and the edit history of both inside tx, returning how many
+comments went with it and the uploads that have to be removed once tx is committed
+*/
+func deletePostRows(tx *sql.Tx, postId string) (int64, []string, error) {
+	imagePaths, err := queryUploadPaths(tx, `
+		SELECT imagepath, thumbpath FROM posts WHERE id = ? AND imagepath != ''
+		UNION ALL
+		SELECT imagepath, thumbpath FROM comments WHERE parentpostid = ? AND imagepath != ''
+	`, postId, postId)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM revisions
+		WHERE (kind = ? AND targetid = ?)
+		OR (kind = ? AND targetid IN (SELECT id FROM comments WHERE parentpostid = ?))
+	`, RevisionKindPost, postId, RevisionKindComment, postId)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	res, err := tx.Exec(`DELETE FROM comments WHERE parentpostid = ?`, postId)
+	if err != nil {
+		return 0, nil, err
+	}
+	commentsRemoved, _ := res.RowsAffected()
+
+	_, err = tx.Exec(`DELETE FROM posts WHERE id = ?`, postId)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return commentsRemoved, imagePaths, nil
+}
+
+/*
+retrieving posts, a page of posts is produced with a constant amount of queries no matter how many
+posts it holds: the comment counts come along with the posts themselves (through the index on
+comments.parentpostid) and who's asking is only resolved once through GetRequester()
+*/
 func RequestPost(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "1") {
-		fmt.Printf("Rank mismatch in RequestPost, invalid perms!\n")
+	requester := GetRequester(r)
+	if !requester.Has(PermRead) {
+		fmt.Printf("Permission mismatch in RequestPost, invalid perms!\n")
 		return
 	}
 
-	// handle the first request: we ask from what index and how many posts
+	// handle the first request: we ask from what index (or cursor) and how many posts
 	displayFromStr := r.FormValue("displayfrompostnumber")
 	amountReqStr := r.FormValue("amountofpostsrequested")
+	cursorStr := r.FormValue("cursor")
+	_, useCursor := r.Form["cursor"]
 
 	displayFromInt, err := strconv.Atoi(displayFromStr)
 	if err != nil {
@@ -302,60 +326,94 @@ func RequestPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	amountReqInt, err := strconv.Atoi(amountReqStr)
-	if err != nil {
+	if err != nil || amountReqInt < 1 {
 		amountReqInt = 20
 	}
+	amountReqInt = min(amountReqInt, maxPostsPerRequest)
+
+	// then we get the actual posts themselves, either after the cursor or at an offset
+	where := "WHERE deleted_at IS NULL"
+	limit := "LIMIT ? OFFSET ?"
+	args := []any{amountReqInt, displayFromInt}
+
+	if useCursor {
+		limit = "LIMIT ?"
+		args = []any{amountReqInt}
+
+		if cursorStr != "" {
+			cursorPinned, cursorId, ok := ParsePostCursor(cursorStr)
+			if !ok {
+				WriteJSONError(w, http.StatusBadRequest, "Invalid cursor")
+				return
+			}
+
+			where += " AND (pinned < ? OR (pinned = ? AND id < ?))"
+			args = []any{cursorPinned, cursorPinned, cursorId, amountReqInt}
+		}
+	}
 
-	// then we get the actual posts themselves
 	query := `
-		SELECT id, username, postcontent, imagepath, timestamp, pinned, locked, isanonymous
+		SELECT
+			id, username, postcontent, rawhtml, imagepath, thumbpath, timestamp, pinned, locked, isanonymous, edited_at,
+			(SELECT COUNT(*) FROM comments WHERE comments.parentpostid = posts.id AND comments.deleted_at IS NULL)
 		FROM POSTS
+		` + where + `
 		ORDER BY pinned DESC, id DESC
-		LIMIT ? OFFSET ?
-	`
+		` + limit
 
-	rows, err := db.Query(query, amountReqInt, displayFromInt)
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			fmt.Println("No posts found, returning null...")
-		} else {
-			log.Fatal(err)
-			return
-		}
+		log.Printf("Error querying posts: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
 	}
 	defer rows.Close()
 
 	var posts []PostData
 
-	currentUsername := GetUsernameFromCookie(r, "userSessionToken")
+	currentUsername := requester.Username
+	canViewAnonymous := requester.Has(PermViewAnonymous)
+	canDeleteAny := requester.Has(PermDeleteAny)
+	canPin := requester.Has(PermPin)
+	canLock := requester.Has(PermLock)
+
 	for rows.Next() {
 		var post PostData
-		var isAnonymous bool
+		var isAnonymous, rawHTML bool
+		var editedAt sql.NullString
 
 		err := rows.Scan(
 			&post.Id,
 			&post.Username,
 			&post.PostContent,
+			&rawHTML,
 			&post.Imagepath,
+			&post.Thumbpath,
 			&post.Timestamp,
 			&post.Pinned,
 			&post.Locked,
 			&isAnonymous,
+			&editedAt,
+			&post.CommentCount,
 		)
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("Error scanning post: %v\n", err)
+			WriteJSONError(w, http.StatusInternalServerError, "Server error")
+			return
 		}
-
-		countQuery := `SELECT COUNT(*) FROM comments WHERE parentpostid = ?`
-		err = db.QueryRow(countQuery, post.Id).Scan(&post.CommentCount)
-		if err != nil {
-			log.Printf("Error counting comments for post ID %s: %v\n", post.Id, err)
-			post.CommentCount = "0"
+		post.EditedAt = editedAt.String
+
+		submitted, _ := time.Parse(time.RFC3339, post.Timestamp)
+		if CanEditContent(requester, PermPost, post.Username, submitted) {
+			canEdit := true
+			post.CanEdit = &canEdit
+			post.Source = EditableContent(post.PostContent, rawHTML)
+			post.RawHTML = rawHTML
 		}
 
 		// hidden name case
 		if isAnonymous {
-			if DoesUserMatchRank(r, "2") {
+			if canViewAnonymous {
 				post.Username = post.Username + " (hidden)"
 			} else {
 				post.Username = "Hidden"
@@ -363,18 +421,16 @@ func RequestPost(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var hasOwnership bool
-		if currentUsername == post.Username || DoesUserMatchRank(r, "2") {
+		if currentUsername == post.Username || canDeleteAny {
 			hasOwnership = true
 			post.HasOwnership = &hasOwnership
 			// fmt.Printf("Post of ID %s is owned by requester\n", post.Id)
 		}
 
-		var canPin bool
-		var canLock bool
-		if DoesUserMatchRank(r, "2") {
-			canPin = true
-			canLock = true
+		if canPin {
 			post.CanPin = &canPin
+		}
+		if canLock {
 			post.CanLock = &canLock
 		}
 
@@ -386,14 +442,63 @@ func RequestPost(w http.ResponseWriter, r *http.Request) {
 
 		posts = append(posts, post)
 	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading posts: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+
+	if !useCursor {
+		json.NewEncoder(w).Encode(posts)
+		return
+	}
+
+	// a short page means we've reached the end, otherwise continue after the last post of this one
+	page := PostPage{Posts: posts, NextCursor: ""}
+	if page.Posts == nil {
+		page.Posts = []PostData{}
+	}
+	if len(posts) == amountReqInt {
+		page.NextCursor = PostCursor(posts[len(posts)-1])
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
+
+// the cursor of a post, the position right after it in the (pinned, id) ordering of RequestPost()
+func PostCursor(post PostData) string {
+	pinned := 0
+	if post.Pinned {
+		pinned = 1
+	}
+
+	return fmt.Sprintf("%d:%s", pinned, post.Id)
+}
+
+func ParsePostCursor(cursor string) (int, int64, bool) {
+	pinnedStr, idStr, found := strings.Cut(cursor, ":")
+	if !found {
+		return 0, 0, false
+	}
+
+	pinned, err := strconv.Atoi(pinnedStr)
+	if err != nil || (pinned != 0 && pinned != 1) {
+		return 0, 0, false
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return pinned, id, true
 }
 
 func PinPost(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "2") {
-		fmt.Printf("Rank mismatch in PinPost, invalid perms!\n")
+	if !HasPermission(r, PermPin) {
+		fmt.Printf("Permission mismatch in PinPost, invalid perms!\n")
 		http.Error(w, "No permission to pin post!", http.StatusForbidden)
 		return
 	}
@@ -401,10 +506,16 @@ func PinPost(w http.ResponseWriter, r *http.Request) {
 	var data PostData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		log.Fatal(err)
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
 	}
 
-	WriteToSQL(`UPDATE posts SET pinned = ? WHERE id = ?`, data.Pinned, data.Id)
+	err = WriteToSQL(`UPDATE posts SET pinned = ? WHERE id = ?`, data.Pinned, data.Id)
+	if err != nil {
+		log.Printf("Error pinning post %s: %v\n", data.Id, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
 	fmt.Printf("Post of ID %s has been pinned: %t\n", data.Id, data.Pinned)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -414,8 +525,8 @@ func PinPost(w http.ResponseWriter, r *http.Request) {
 }
 
 func LockPost(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "2") {
-		fmt.Printf("Rank mismatch in LockPost, invalid perms!\n")
+	if !HasPermission(r, PermLock) {
+		fmt.Printf("Permission mismatch in LockPost, invalid perms!\n")
 		http.Error(w, "No permission to lock post!", http.StatusForbidden)
 		return
 	}
@@ -423,10 +534,16 @@ func LockPost(w http.ResponseWriter, r *http.Request) {
 	var data PostData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		log.Fatal(err)
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
 	}
 
-	WriteToSQL(`UPDATE posts SET locked = ? WHERE id = ?`, data.Locked, data.Id)
+	err = WriteToSQL(`UPDATE posts SET locked = ? WHERE id = ?`, data.Locked, data.Id)
+	if err != nil {
+		log.Printf("Error locking post %s: %v\n", data.Id, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
 
 	fmt.Printf("Post of ID %s has been locked: %t\n", data.Id, data.Locked)
 
@@ -442,9 +559,14 @@ type CommentData struct {
 	Username     string `json:"username"`
 	PostContent  string `json:"postcontent"`
 	Imagepath    string `json:"imagepath"`
+	Thumbpath    string `json:"thumbpath"`
 	Timestamp    string `json:"timestamp"`
 	IsComment    bool   `json:"iscomment"`
 	HasOwnership *bool  `json:"hasownership,omitempty"`
+	EditedAt     string `json:"editedat,omitempty"`
+	CanEdit      *bool  `json:"canedit,omitempty"`
+	Source       string `json:"source,omitempty"`
+	RawHTML      bool   `json:"rawhtml,omitempty"`
 }
 
 func AddComment(w http.ResponseWriter, r *http.Request) {
@@ -452,15 +574,17 @@ func AddComment(w http.ResponseWriter, r *http.Request) {
 		NOTE: it would probably be a smart idea to add an if-check for whether
 		parentpostid actually constitutes as a post or not
 	*/
-	if !DoesUserMatchRank(r, "1") {
-		fmt.Printf("Rank mismatch in AddComment, invalid perms!\n")
+	if !HasPermission(r, PermComment) {
+		fmt.Printf("Permission mismatch in AddComment, invalid perms!\n")
+		if ban, banned := GetActiveBan(GetUsernameFromCookie(r, "userSessionToken")); banned {
+			WriteJSONError(w, http.StatusForbidden, ban.Message())
+			return
+		}
 		http.Error(w, "No permission to upload comment!", http.StatusForbidden)
 		return
 	}
 
-	err := r.ParseMultipartForm(10 << 20)
-	if err != nil {
-		log.Fatal(err)
+	if !ParseUploadForm(w, r) {
 		return
 	}
 
@@ -471,7 +595,7 @@ func AddComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// a check for whether the post is locked: return error if it is and user isn't admin
+	// a check for whether the post is locked: return error if it is and user can't lock posts themselves
 	var isLocked bool
 	err = db.QueryRow(`SELECT locked FROM posts WHERE id = ?`, parentID).Scan(&isLocked)
 	if err != nil {
@@ -479,7 +603,7 @@ func AddComment(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Server error", http.StatusInternalServerError)
 		return
 	}
-	if isLocked && !DoesUserMatchRank(r, "2") {
+	if isLocked && !HasPermission(r, PermLock) {
 		fmt.Println("Post is locked, not replying...")
 		http.Error(w, "Cannot comment under post, locked!", http.StatusForbidden)
 		return
@@ -487,7 +611,7 @@ func AddComment(w http.ResponseWriter, r *http.Request) {
 
 	// a check for whether the post exists: return error if it doesn't exist
 	var exists bool
-	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)`, parentID).Scan(&exists)
+	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM posts WHERE id = ? AND deleted_at IS NULL)`, parentID).Scan(&exists)
 	if err != nil {
 		log.Printf("Database error checking parent post: %v", err)
 		http.Error(w, "Server error", http.StatusInternalServerError)
@@ -498,37 +622,9 @@ func AddComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var imagePath string
-	file, handler, err := r.FormFile("image")
-
-	if err == nil {
-		defer file.Close()
-
-		if !IsAcceptedFileFormat(handler.Filename, acceptedExts) || !IsAcceptedMIME(file, acceptedMIMEs) {
-			http.Error(w, "Unsupported file format!", http.StatusUnsupportedMediaType)
-			return
-		}
-
-		safeName := TruncateFilename(handler.Filename, 64)
-		uniqueName := fmt.Sprintf("%d_%s", time.Now().UnixNano(), safeName)
-		imagePath = filepath.Join("uploads", uniqueName)
-		dst, err := os.Create(imagePath)
-		if err != nil {
-			fmt.Printf("Error in os.Create() of imagefile!\n")
-			http.Error(w, "Encountered error with file on back-end.", http.StatusForbidden)
-			return
-		}
-		defer dst.Close()
-
-		_, err = io.Copy(dst, file)
-		if err != nil {
-			fmt.Printf("Error in io.Copy() of imagefile!\n")
-			http.Error(w, "Encountered error with file on back-end.", http.StatusForbidden)
-			return
-		}
-	} else if err != http.ErrMissingFile {
-		fmt.Printf("Error, image file seems to be missing!\n")
-		http.Error(w, "Encountered error with file on back-end.", http.StatusForbidden)
+	imagePath, thumbPath, err := SaveUpload(r)
+	if err != nil {
+		WriteUploadError(w, err)
 		return
 	}
 
@@ -543,15 +639,22 @@ func AddComment(w http.ResponseWriter, r *http.Request) {
 	// check whether we should sanitize or not (useful if moderators want better control with injecting HTML)
 	var shouldBeDesanitized = ParseBoolOrFalse(r.FormValue("reject-sanitize"))
 	fmt.Println("Sanitization:", shouldBeDesanitized)
-	if !(DoesUserMatchRank(r, "2") && shouldBeDesanitized) {
+	rawHTML := HasPermission(r, PermPostHTML) && shouldBeDesanitized
+	if !rawHTML {
 		fmt.Println("Sanitizing comment content to avoid malicious actions...")
 		postContent = html.EscapeString(postContent)
 	}
 
-	WriteToSQL(`
-		INSERT INTO COMMENTS (id, parentpostid, username, postcontent, imagepath, isanonymous)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, id, ParentPostID, currentUsername, postContent, imagePath, isAnonymous)
+	err = WriteToSQL(`
+		INSERT INTO COMMENTS (id, parentpostid, username, postcontent, rawhtml, imagepath, thumbpath, isanonymous)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, ParentPostID, currentUsername, postContent, rawHTML, imagePath, thumbPath, isAnonymous)
+	if err != nil {
+		log.Printf("Error inserting comment: %v\n", err)
+		ReleaseUploads(imagePath, thumbPath)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -559,51 +662,41 @@ func AddComment(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// same as DeletePost(), comments go to the trash first
 func DeleteComment(w http.ResponseWriter, r *http.Request) {
 	var data CommentData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		log.Fatal(err)
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
 	}
 
 	currentUsername := GetUsernameFromCookie(r, "userSessionToken")
-	commentOwner, err := QueryFromSQL(`SELECT username FROM comments WHERE id = ?`, data.Id)
+	commentOwner, err := QueryFromSQL(`SELECT username FROM comments WHERE id = ? AND deleted_at IS NULL`, data.Id)
 	if err != nil {
 		fmt.Println("Warning: Can't get comment owner for some reason! commentOwner is: ", commentOwner)
+		WriteJSONError(w, http.StatusNotFound, "Comment does not exist")
 		return
 	}
 
-	if !DoesUserMatchRank(r, "2") {
+	if !HasPermission(r, PermDeleteAny) {
 		if currentUsername != commentOwner {
 			fmt.Println("DeleteComment request discarded due to invalid perms")
+			WriteJSONError(w, http.StatusForbidden, "No permission to delete comment!")
 			return
 		}
 	}
 
-	commentImagePath, err := QueryFromSQL(`SELECT imagepath FROM comments WHERE id = ?`, data.Id)
+	err = WriteToSQL(`
+		UPDATE comments SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? WHERE id = ?
+	`, currentUsername, data.Id)
 	if err != nil {
-		fmt.Println("Warning: Can't commentImagePath! Defaulting to empty...")
-		commentImagePath = ""
-	}
-
-	if commentImagePath != "" {
-		cwd, err := os.Getwd()
-		if err != nil {
-			log.Fatal(err)
-			return
-		}
-
-		joinedImagePath := filepath.Join(cwd, commentImagePath)
-		fmt.Println(joinedImagePath)
-		err = os.Remove(joinedImagePath)
-
-		if err != nil {
-			log.Fatal(err)
-		}
+		log.Printf("Error deleting comment ID %s: %v\n", data.Id, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
 	}
 
-	WriteToSQL(`DELETE FROM comments WHERE id = ?`, data.Id)
-	fmt.Printf("Comment ID %s deleted successfully\n", data.Id)
+	fmt.Printf("Comment ID %s moved to the trash by %s\n", data.Id, currentUsername)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -611,9 +704,40 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+/*
+deletes a single comment with its edit history inside tx, returning its upload and thumbnail (if any)
+that have to be removed once tx is committed
+*/
+func deleteCommentRows(tx *sql.Tx, commentId string) ([]string, error) {
+	var imagePath, thumbPath string
+	err := tx.QueryRow(`SELECT imagepath, thumbpath FROM comments WHERE id = ?`, commentId).Scan(&imagePath, &thumbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM revisions WHERE kind = ? AND targetid = ?`, RevisionKindComment, commentId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM comments WHERE id = ?`, commentId)
+	if err != nil {
+		return nil, err
+	}
+
+	var imagePaths []string
+	for _, path := range []string{imagePath, thumbPath} {
+		if path != "" {
+			imagePaths = append(imagePaths, path)
+		}
+	}
+	return imagePaths, nil
+}
+
 func RequestComment(w http.ResponseWriter, r *http.Request) {
-	if !DoesUserMatchRank(r, "1") {
-		fmt.Printf("Rank mismatch in RequestComments, invalid perms!\n")
+	requester := GetRequester(r)
+	if !requester.Has(PermRead) {
+		fmt.Printf("Permission mismatch in RequestComments, invalid perms!\n")
 		http.Error(w, "Invalid permission when trying to request comment!", http.StatusUnsupportedMediaType)
 		return
 	}
@@ -621,37 +745,63 @@ func RequestComment(w http.ResponseWriter, r *http.Request) {
 	var data CommentData
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
-		log.Fatal(err)
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
 	}
 
-	query := `SELECT id, username, postcontent, imagepath, timestamp, isanonymous FROM COMMENTS WHERE parentpostid = ?`
+	query := `
+		SELECT id, username, postcontent, rawhtml, imagepath, thumbpath, timestamp, isanonymous, edited_at
+		FROM COMMENTS
+		WHERE parentpostid = ? AND deleted_at IS NULL
+		AND parentpostid IN (SELECT id FROM posts WHERE deleted_at IS NULL)
+	`
 	rows, err := db.Query(query, data.ParentPostID)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Error querying comments: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
 	}
 	defer rows.Close()
 
 	var comments []CommentData
 
-	currentUsername := GetUsernameFromCookie(r, "userSessionToken")
+	currentUsername := requester.Username
+	canViewAnonymous := requester.Has(PermViewAnonymous)
+	canDeleteAny := requester.Has(PermDeleteAny)
+
 	for rows.Next() {
 		var comment CommentData
-		var isAnonymous bool
+		var isAnonymous, rawHTML bool
+		var editedAt sql.NullString
 
 		err := rows.Scan(
 			&comment.Id,
 			&comment.Username,
 			&comment.PostContent,
+			&rawHTML,
 			&comment.Imagepath,
+			&comment.Thumbpath,
 			&comment.Timestamp,
 			&isAnonymous,
+			&editedAt,
 		)
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("Error scanning comment: %v\n", err)
+			WriteJSONError(w, http.StatusInternalServerError, "Server error")
+			return
+		}
+		comment.EditedAt = editedAt.String
+
+		submitted, _ := time.Parse(time.RFC3339, comment.Timestamp)
+		if CanEditContent(requester, PermComment, comment.Username, submitted) {
+			canEdit := true
+			comment.CanEdit = &canEdit
+			comment.Source = EditableContent(comment.PostContent, rawHTML)
+			comment.RawHTML = rawHTML
 		}
 
 		if isAnonymous {
-			if DoesUserMatchRank(r, "2") {
+			if canViewAnonymous {
 				comment.Username = comment.Username + " (hidden)"
 			} else {
 				comment.Username = "hidden"
@@ -659,7 +809,7 @@ func RequestComment(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var hasOwnership bool
-		if currentUsername == comment.Username || DoesUserMatchRank(r, "2") {
+		if currentUsername == comment.Username || canDeleteAny {
 			hasOwnership = true
 			comment.HasOwnership = &hasOwnership
 		}
@@ -675,14 +825,19 @@ func RequestComment(w http.ResponseWriter, r *http.Request) {
 		comment.IsComment = true
 		comments = append(comments, comment)
 	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading comments: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(comments)
 }
 
-func RegexLink(contentString string) string {
-	linkRegex := regexp.MustCompile(`(https||http)?://[^\s]+`)
+var linkRegex = regexp.MustCompile(`(https||http)?://[^\s]+`)
 
+func RegexLink(contentString string) string {
 	return linkRegex.ReplaceAllStringFunc(contentString, func(url string) string {
 		return fmt.Sprintf(`<a href="%s" target="_blank">%s</a>`, url, url)
 	})
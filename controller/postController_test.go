@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// fills the test database with posts of a few users and comments spread over them
+func seedPosts(tb testing.TB, posts, comments int) {
+	tb.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	users := []string{"alice", "bob", "carol", "dave"}
+	for i := 1; i <= posts; i++ {
+		_, err := tx.Exec(`
+			INSERT INTO posts (id, username, postcontent, imagepath, pinned) VALUES (?, ?, ?, '', ?)
+		`, i, users[i%len(users)], fmt.Sprintf("post number %d :) see https://example.com/%d", i, i), i%500 == 0)
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	for i := 1; i <= comments; i++ {
+		_, err := tx.Exec(`
+			INSERT INTO comments (id, parentpostid, username, postcontent, imagepath) VALUES (?, ?, ?, ?, '')
+		`, posts+i, 1+i%posts, users[i%len(users)], fmt.Sprintf("comment number %d", i))
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// a full page of the feed for a logged in user, out of 5000 posts with 30000 comments
+func BenchmarkRequestPost(b *testing.B) {
+	openTestDB(b)
+	addTestUser(b, "alice", "correct horse", RoleUser)
+	seedPosts(b, 5000, 30000)
+
+	session, err := Sessions.Create("alice")
+	if err != nil {
+		b.Fatal(err)
+	}
+	cookie := &http.Cookie{Name: "userSessionToken", Value: session.Token}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/requestPost?cursor=&amountofpostsrequested=100", nil)
+		req.AddCookie(cookie)
+
+		rec := httptest.NewRecorder()
+		RequestPost(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("RequestPost() = %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+/*
+the sqlite3 driver, counting every statement that goes through it. the connections only have Prepare(),
+so database/sql prepares everything it runs, queries and execs alike
+*/
+type countingDriver struct {
+	driver.Driver
+	statements atomic.Int64
+}
+
+type countingConn struct {
+	driver.Conn
+	counter *countingDriver
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn, d}, nil
+}
+
+func (c countingConn) Prepare(query string) (driver.Stmt, error) {
+	c.counter.statements.Add(1)
+	return c.Conn.Prepare(query)
+}
+
+var statementCounter = &countingDriver{Driver: &sqlite3.SQLiteDriver{}}
+
+func init() {
+	sql.Register("sqlite3-counting", statementCounter)
+}
+
+// points db at the test database again, through statementCounter
+func countStatements(t *testing.T) {
+	t.Helper()
+
+	var seq int
+	var name, file string
+	if err := db.QueryRow(`PRAGMA database_list`).Scan(&seq, &name, &file); err != nil {
+		t.Fatal(err)
+	}
+
+	counted, err := sql.Open("sqlite3-counting", file+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { counted.Close() })
+	db = counted
+}
+
+// a page of the feed takes as many statements no matter how many posts are on it
+func TestRequestPostQueryCount(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+	seedPosts(t, 200, 1000)
+	countStatements(t)
+
+	statements := func(amount int) int64 {
+		req := requestAs(t, "alice", http.MethodGet, fmt.Sprintf("/api/requestPost?cursor=&amountofpostsrequested=%d", amount), &bytes.Buffer{}, "")
+
+		before := statementCounter.statements.Load()
+		rec := httptest.NewRecorder()
+		RequestPost(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("RequestPost() of %d posts = %d: %s", amount, rec.Code, rec.Body.String())
+		}
+		return statementCounter.statements.Load() - before
+	}
+
+	small, large := statements(10), statements(100)
+	if small == 0 || small != large {
+		t.Fatalf("a page of 10 posts takes %d statement(s), a page of 100 takes %d", small, large)
+	}
+}
+
+// a database error is answered with a 500 instead of taking the whole server down
+func TestRequestPostQueryError(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+	seedPosts(t, 5, 5)
+
+	if _, err := db.Exec(`DROP TABLE comments`); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	RequestPost(rec, requestAs(t, "alice", http.MethodGet, "/api/requestPost?cursor=&amountofpostsrequested=10", &bytes.Buffer{}, ""))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("RequestPost() without a comments table = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// a body that isn't JSON is turned away instead of taking the whole server down
+func TestPinLockPostBadBody(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "sannu", "correct horse", RoleAdmin)
+	seedPosts(t, 1, 0)
+
+	for name, handler := range map[string]http.HandlerFunc{"PinPost": PinPost, "LockPost": LockPost, "RequestComment": RequestComment} {
+		rec := httptest.NewRecorder()
+		handler(rec, requestAs(t, "sannu", http.MethodPost, "/api/"+name, bytes.NewBufferString("not json"), "application/json"))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s() with a broken body = %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+
+	adminRequest(t, LockPost, `{"id": "1", "locked": true}`, http.StatusOK)
+	var locked bool
+	db.QueryRow(`SELECT locked FROM posts WHERE id = 1`).Scan(&locked)
+	if !locked {
+		t.Fatal("post wasn't locked")
+	}
+}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	general rate limiting for the api, to avoid api-spam slop: every wrapped route gets its own token
+	bucket rule, with a bucket per logged in user and one per client IP. a request has to be allowed by
+	both buckets, so logging in with several accounts from the same IP doesn't get around it.
+
+	admins (anyone allowed to manage users) are exempt. whenever a bucket runs out we answer with a 429
+	JSON error (plus Retry-After) that the front-end can display.
+
+	the rules have defaults in DefaultRateRules, but can be overridden per route through the environment
+	as RATE_LIMIT_<ROUTE>=<requests>/<duration>, i.e RATE_LIMIT_ADDPOST=5/1m
+*/
+
+/*
+struct for a rate limiting rule
+  - Requests: how many requests can be done in a burst, the capacity of the bucket
+  - Per: how long it takes for a completely empty bucket to refill
+*/
+type RateRule struct {
+	Requests int
+	Per      time.Duration
+}
+
+var DefaultRateRules = map[string]RateRule{
+	"addPost":        {Requests: 5, Per: time.Minute},
+	"addComment":     {Requests: 10, Per: time.Minute},
+	"requestPost":    {Requests: 60, Per: time.Minute},
+	"requestComment": {Requests: 60, Per: time.Minute},
+	"register":       {Requests: 5, Per: time.Hour},
+}
+
+// idle buckets that have refilled completely are forgotten after this long
+const rateBucketIdle = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type RateLimiter struct {
+	rule    RateRule
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[string]*RateLimiter)
+)
+
+// returns the rule for route, taking overrides from the environment into account
+func LoadRateRule(route string) RateRule {
+	rule := DefaultRateRules[route]
+
+	value, ok := os.LookupEnv("RATE_LIMIT_" + strings.ToUpper(route))
+	if !ok {
+		return rule
+	}
+
+	requestsStr, perStr, ok := strings.Cut(value, "/")
+	requests, err1 := strconv.Atoi(requestsStr)
+	per, err2 := time.ParseDuration(perStr)
+	if !ok || err1 != nil || err2 != nil || requests < 1 || per <= 0 {
+		fmt.Printf("Invalid RATE_LIMIT_%s of %s, using default...\n", strings.ToUpper(route), value)
+		return rule
+	}
+
+	return RateRule{Requests: requests, Per: per}
+}
+
+func NewRateLimiter(rule RateRule) *RateLimiter {
+	return &RateLimiter{
+		rule:    rule,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (l *RateLimiter) refill(key string, now time.Time) *tokenBucket {
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.rule.Requests), last: now}
+		l.buckets[key] = bucket
+		return bucket
+	}
+
+	perToken := l.rule.Per.Seconds() / float64(l.rule.Requests)
+	bucket.tokens += now.Sub(bucket.last).Seconds() / perToken
+	bucket.tokens = min(bucket.tokens, float64(l.rule.Requests))
+	bucket.last = now
+
+	return bucket
+}
+
+/*
+takes a token out of the bucket of every key, but only if all of them have one left. otherwise nothing
+is taken and how long until all of them would have a token is returned
+*/
+func (l *RateLimiter) Take(keys ...string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	perToken := l.rule.Per.Seconds() / float64(l.rule.Requests)
+
+	var wait time.Duration
+	buckets := make([]*tokenBucket, 0, len(keys))
+	for _, key := range keys {
+		bucket := l.refill(key, now)
+		buckets = append(buckets, bucket)
+
+		if bucket.tokens < 1 {
+			missing := time.Duration((1 - bucket.tokens) * perToken * float64(time.Second))
+			wait = max(wait, missing)
+		}
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+
+	return true, 0
+}
+
+func (l *RateLimiter) Prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) > max(rateBucketIdle, l.rule.Per) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+/*
+middleware that rate limits next with the rule of route. the limiter is shared between every handler
+wrapped with the same route name
+*/
+func RateLimit(route string, next http.HandlerFunc) http.HandlerFunc {
+	rateLimitersMu.Lock()
+	limiter, ok := rateLimiters[route]
+	if !ok {
+		limiter = NewRateLimiter(LoadRateRule(route))
+		rateLimiters[route] = limiter
+	}
+	rateLimitersMu.Unlock()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if HasPermission(r, PermManageUsers) {
+			next(w, r)
+			return
+		}
+
+		keys := []string{"ip:" + GetClientIP(r)}
+		if username := GetUsernameFromCookie(r, "userSessionToken"); username != "" {
+			keys = append(keys, "user:"+strings.ToLower(username))
+		}
+
+		allowed, retryAfter := limiter.Take(keys...)
+		if !allowed {
+			fmt.Printf("Rate limited %v on %s\n", keys, route)
+			WriteTooManyRequests(w, retryAfter, "You're doing that too often, slow down!")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// runs Prune() on every rate limiter in the background every interval for as long as the server is up
+func StartRateLimitPruner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			rateLimitersMu.Lock()
+			for _, limiter := range rateLimiters {
+				limiter.Prune()
+			}
+			rateLimitersMu.Unlock()
+		}
+	}()
+}
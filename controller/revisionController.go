@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+	editing posts and comments, so that fixing a typo doesn't mean deleting and reposting (which would
+	orphan every comment hanging off the old parentpostid).
+
+	owners can edit their own stuff for Cfg.EditWindow after submitting it, anyone with "edit-any" can
+	edit anything at any time. every edit stores the version it replaced in "revisions", so admins can
+	look through the whole edit history of a post or comment from the dashboard.
+
+	since posts and comments share their IDs through global_ids, an ID alone is enough to find either
+*/
+
+const (
+	RevisionKindPost    = "post"
+	RevisionKindComment = "comment"
+)
+
+/*
+struct for the data EditPost() and EditComment() take
+  - Id: ID of the post or comment to edit
+  - PostContent: the new text of the post or comment
+  - RejectSanitize: whether to keep HTML in the new text, only for those allowed to post HTML. the
+    front-end passes along the "rawhtml" of what is being edited, so raw HTML stays raw HTML
+*/
+type EditData struct {
+	Id             string `json:"id"`
+	PostContent    string `json:"postcontent"`
+	RejectSanitize bool   `json:"reject-sanitize"`
+}
+
+/*
+struct for a single replaced version of a post or comment
+  - PostContent: the text as it was before the edit
+  - EditedBy: username of whoever made the edit that replaced it
+  - CreatedAt: timestamp of when it was replaced
+*/
+type RevisionData struct {
+	PostContent string `json:"postcontent"`
+	EditedBy    string `json:"editedby"`
+	CreatedAt   string `json:"createdat"`
+}
+
+/*
+struct for the edit history of a post or comment that we can assemble and serve
+  - Id: ID of the post or comment
+  - Kind: either "post" or "comment"
+  - Username: Username of the person that created it
+  - PostContent: the current text
+  - Timestamp: timestamp of when it was submitted
+  - EditedAt: timestamp of the last edit, empty if never edited
+  - Revisions: every replaced version, oldest first
+*/
+type RevisionHistory struct {
+	Id          string         `json:"id"`
+	Kind        string         `json:"kind"`
+	Username    string         `json:"username"`
+	PostContent string         `json:"postcontent"`
+	Timestamp   string         `json:"timestamp"`
+	EditedAt    string         `json:"editedat"`
+	Revisions   []RevisionData `json:"revisions"`
+}
+
+/*
+whether requester can edit something owner submitted at submitted. owners also need the
+permission to post it in the first place, so bans apply to editing as well
+*/
+func CanEditContent(requester Requester, permission, owner string, submitted time.Time) bool {
+	if requester.Has(PermEditAny) {
+		return true
+	}
+
+	if requester.Username == "" || requester.Username != owner || !requester.Has(permission) {
+		return false
+	}
+
+	return Cfg.EditWindow > 0 && time.Since(submitted) <= Cfg.EditWindow
+}
+
+/*
+the text of a post or comment the way it was typed, to start editing from. escaped content gets
+unescaped again, since edits are escaped once more on the way in. emoticons and links are only
+turned into HTML when serving, so the stored text still has them the way they were typed
+*/
+func EditableContent(postContent string, rawHTML bool) string {
+	if rawHTML {
+		return postContent
+	}
+	return html.UnescapeString(postContent)
+}
+
+func EditPost(w http.ResponseWriter, r *http.Request) {
+	editContent(w, r, RevisionKindPost)
+}
+
+func EditComment(w http.ResponseWriter, r *http.Request) {
+	editContent(w, r, RevisionKindComment)
+}
+
+func editContent(w http.ResponseWriter, r *http.Request, kind string) {
+	table, permission := "posts", PermPost
+	if kind == RevisionKindComment {
+		table, permission = "comments", PermComment
+	}
+
+	var data EditData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	requester := GetRequester(r)
+
+	postContent := data.PostContent
+	rawHTML := requester.Has(PermPostHTML) && data.RejectSanitize
+	if !rawHTML {
+		postContent = html.EscapeString(postContent)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction in edit of %s: %v\n", kind, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer tx.Rollback()
+
+	var owner, currentContent string
+	var currentRawHTML bool
+	var submitted time.Time
+	err = tx.QueryRow(`
+		SELECT username, timestamp, postcontent, rawhtml FROM `+table+` WHERE id = ? AND deleted_at IS NULL
+	`, data.Id).Scan(&owner, &submitted, &currentContent, &currentRawHTML)
+	if err == sql.ErrNoRows {
+		WriteJSONError(w, http.StatusNotFound, "Nothing to edit, it does not exist")
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying %s %s for edit: %v\n", kind, data.Id, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if !CanEditContent(requester, permission, owner, submitted) {
+		fmt.Printf("Edit of %s %s discarded due to invalid perms\n", kind, data.Id)
+		WriteJSONError(w, http.StatusForbidden, "You can't edit this (anymore)")
+		return
+	}
+
+	if postContent == currentContent && rawHTML == currentRawHTML {
+		WriteJSONError(w, http.StatusBadRequest, "Nothing changed")
+		return
+	}
+
+	currentUsername := requester.Username
+	_, err = tx.Exec(`
+		INSERT INTO revisions (kind, targetid, postcontent, edited_by)
+		VALUES (?, ?, ?, ?)
+	`, kind, data.Id, currentContent, currentUsername)
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE `+table+` SET postcontent = ?, rawhtml = ?, edited_at = CURRENT_TIMESTAMP WHERE id = ?
+		`, postContent, rawHTML, data.Id)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error editing %s %s: %v\n", kind, data.Id, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	fmt.Printf("%s ID %s edited by %s\n", kind, data.Id, currentUsername)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+	})
+}
+
+func RequestRevisions(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermEditAny) {
+		fmt.Printf("Permission mismatch in RequestRevisions, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to view edit history!")
+		return
+	}
+
+	history := RevisionHistory{
+		Id:        r.URL.Query().Get("id"),
+		Revisions: []RevisionData{},
+	}
+
+	var editedAt sql.NullString
+	for _, kind := range []string{RevisionKindPost, RevisionKindComment} {
+		table := "posts"
+		if kind == RevisionKindComment {
+			table = "comments"
+		}
+
+		err := db.QueryRow(`
+			SELECT username, postcontent, timestamp, edited_at FROM `+table+` WHERE id = ?
+		`, history.Id).Scan(&history.Username, &history.PostContent, &history.Timestamp, &editedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error querying %s %s for revisions: %v\n", kind, history.Id, err)
+			WriteJSONError(w, http.StatusInternalServerError, "Server error")
+			return
+		}
+
+		history.Kind = kind
+		break
+	}
+
+	if history.Kind == "" {
+		WriteJSONError(w, http.StatusNotFound, "No post or comment with that ID")
+		return
+	}
+	history.EditedAt = editedAt.String
+
+	rows, err := db.Query(`
+		SELECT postcontent, edited_by, created_at
+		FROM revisions
+		WHERE kind = ? AND targetid = ?
+		ORDER BY id
+	`, history.Kind, history.Id)
+	if err != nil {
+		log.Printf("Error querying revisions: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revision RevisionData
+		if err := rows.Scan(&revision.PostContent, &revision.EditedBy, &revision.CreatedAt); err != nil {
+			log.Printf("Error scanning revision: %v\n", err)
+			continue
+		}
+
+		history.Revisions = append(history.Revisions, revision)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
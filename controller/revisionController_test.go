@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// a request carrying the session cookie of a freshly logged in username
+func requestAs(t *testing.T, username, method, target string, body *bytes.Buffer, contentType string) *http.Request {
+	t.Helper()
+
+	session, err := Sessions.Create(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(method, target, body)
+	req.AddCookie(&http.Cookie{Name: "userSessionToken", Value: session.Token})
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
+
+func addTestPost(t *testing.T, username, content string, rejectSanitize bool) {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("postcontent", content)
+	form.WriteField("reject-sanitize", fmt.Sprint(rejectSanitize))
+	form.Close()
+
+	rec := httptest.NewRecorder()
+	AddPost(rec, requestAs(t, username, http.MethodPost, "/api/addPost", &body, form.FormDataContentType()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("AddPost() = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// the newest post as username gets to see it
+func latestPost(t *testing.T, username string) PostData {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	RequestPost(rec, requestAs(t, username, http.MethodGet, "/api/requestPost?cursor=&amountofpostsrequested=1", &bytes.Buffer{}, ""))
+
+	var page PostPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil || len(page.Posts) != 1 {
+		t.Fatalf("RequestPost() = %d %v", rec.Code, err)
+	}
+	return page.Posts[0]
+}
+
+func editTestPost(t *testing.T, username string, post PostData, content string) {
+	t.Helper()
+
+	body, _ := json.Marshal(EditData{Id: post.Id, PostContent: content, RejectSanitize: post.RawHTML})
+
+	rec := httptest.NewRecorder()
+	EditPost(rec, requestAs(t, username, http.MethodPost, "/api/editPost", bytes.NewBuffer(body), "application/json"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("EditPost() = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// editing starts from the text as typed and saves it back the same way it was posted
+func TestEditKeepsSource(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "sannu", "correct horse", RoleAdmin)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+
+	typed := `<3 see https://example.com`
+	addTestPost(t, "alice", typed, true)
+
+	post := latestPost(t, "alice")
+	if post.Source != typed || post.RawHTML {
+		t.Fatalf("source of an escaped post = %q (raw %v), want %q", post.Source, post.RawHTML, typed)
+	}
+	if strings.Contains(post.PostContent, "<3") || !strings.Contains(post.PostContent, "<a ") {
+		t.Fatalf("rendered post = %q", post.PostContent)
+	}
+
+	editTestPost(t, "alice", post, post.Source+" too")
+	if edited := latestPost(t, "alice"); edited.Source != typed+" too" || edited.RawHTML {
+		t.Fatalf("source after editing = %q (raw %v)", edited.Source, edited.RawHTML)
+	}
+
+	raw := `<b>announcement</b>`
+	addTestPost(t, "sannu", raw, true)
+
+	post = latestPost(t, "sannu")
+	if post.Source != raw || !post.RawHTML || post.PostContent != raw {
+		t.Fatalf("raw HTML post = %q, source %q (raw %v)", post.PostContent, post.Source, post.RawHTML)
+	}
+
+	editTestPost(t, "sannu", post, `<i>announcement</i>`)
+	if edited := latestPost(t, "sannu"); edited.PostContent != `<i>announcement</i>` || !edited.RawHTML {
+		t.Fatalf("raw HTML post after editing = %q (raw %v)", edited.PostContent, edited.RawHTML)
+	}
+
+	// someone who can't edit it doesn't get the source either
+	if post := latestPost(t, "alice"); post.Source != "" || post.CanEdit != nil {
+		t.Fatalf("alice sees the source of sannu's post: %q", post.Source)
+	}
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+/*
+	named roles and the permissions they grant, replacing the old numeric ranks where "1" was a user and
+	"2" was an admin and nothing could sit in between.
+
+	every user has a role ("user", "moderator", "admin" out of the box) and every role maps to a set of
+	fine-grained permissions in "role_permissions". handlers only ever ask HasPermission(r, perm), so a
+	moderator can for example pin and lock without being able to manage users or emoticons.
+
+	the role -> permissions mapping is loaded into memory on startup (like the emoticons are), call
+	LoadPermissionsFromDB() again whenever it changes
+*/
+
+const (
+	PermRead                = "read"
+	PermPost                = "post"
+	PermComment             = "comment"
+	PermPostHTML            = "post-html"
+	PermPin                 = "pin"
+	PermLock                = "lock"
+	PermDeleteAny           = "delete-any"
+	PermEditAny             = "edit-any"
+	PermViewAnonymous       = "view-anonymous"
+	PermViewDashboard       = "view-dashboard"
+	PermBanUsers            = "ban-users"
+	PermManageUsers         = "manage-users"
+	PermManageEmoticons     = "manage-emoticons"
+	PermManageAnnouncements = "manage-announcements"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var (
+	rolePermissionsMu sync.RWMutex
+	RolePermissions   map[string]map[string]bool
+)
+
+func LoadPermissionsFromDB() error {
+	rows, err := db.Query(`
+		SELECT roles.name, role_permissions.permission
+		FROM roles
+		LEFT JOIN role_permissions ON role_permissions.role = roles.name
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	permissions := make(map[string]map[string]bool)
+	for rows.Next() {
+		var role string
+		var permission sql.NullString
+		if err := rows.Scan(&role, &permission); err != nil {
+			return err
+		}
+
+		if permissions[role] == nil {
+			permissions[role] = make(map[string]bool)
+		}
+		if permission.Valid {
+			permissions[role][permission.String] = true
+		}
+	}
+
+	rolePermissionsMu.Lock()
+	RolePermissions = permissions
+	rolePermissionsMu.Unlock()
+
+	return rows.Err()
+}
+
+func RoleExists(role string) bool {
+	rolePermissionsMu.RLock()
+	defer rolePermissionsMu.RUnlock()
+
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+func RoleHasPermission(role, permission string) bool {
+	rolePermissionsMu.RLock()
+	defer rolePermissionsMu.RUnlock()
+
+	return RolePermissions[role][permission]
+}
+
+// role of the account named username in any case (see CanonicalUsername()), empty if there's none
+func GetUserRole(username string) string {
+	role, err := QueryFromSQL(`
+		SELECT role FROM users WHERE username = ? COLLATE NOCASE
+	`, username)
+	if err != nil {
+		fmt.Println("Error validating GetUserRole, defaulting...")
+		role = ""
+	}
+
+	return role
+}
+
+/*
+struct for whoever is behind a request, resolved once so handlers going through many rows (like
+RequestPost()) don't look up the session and role again for every single permission check
+  - Username: Username of the logged in user, empty if not logged in
+  - Role: role of the user
+  - Ban: the active ban of the user, only meaningful if Banned
+  - Banned: whether the user currently has an active ban
+*/
+type Requester struct {
+	Username string
+	Role     string
+	Ban      BanData
+	Banned   bool
+}
+
+func GetRequester(r *http.Request) Requester {
+	var requester Requester
+
+	requester.Username = GetUsernameFromCookie(r, "userSessionToken")
+	if requester.Username == "" {
+		return requester
+	}
+
+	requester.Role = GetUserRole(requester.Username)
+	requester.Ban, requester.Banned = GetActiveBan(requester.Username)
+
+	return requester
+}
+
+// whether the requester has been granted permission through their role
+func (q Requester) Has(permission string) bool {
+	if q.Username == "" {
+		return false
+	}
+
+	// banned users lose every permission, apart from reading when the ban is read-only
+	if q.Banned && (!q.Ban.ReadOnly || permission != PermRead) {
+		return false
+	}
+
+	return RoleHasPermission(q.Role, permission)
+}
+
+// whether the user behind the session of the request has been granted permission through their role
+func HasPermission(r *http.Request, permission string) bool {
+	return GetRequester(r).Has(permission)
+}
@@ -0,0 +1,363 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	storing uploads in an S3 bucket (or anything else speaking the S3 API, MinIO, R2, ...).
+
+	instead of pulling in the whole AWS SDK for five requests, they're made with net/http and signed
+	with AWS signature version 4 by hand (signS3Request()). see:
+	https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+
+	path-style addressing (https://endpoint/bucket/name) is what MinIO and most stand-ins expect,
+	virtual-hosted style (https://bucket.endpoint/name) is what AWS prefers, Cfg.S3PathStyle picks
+*/
+
+const s3Service = "s3"
+
+// hash of an empty payload, which is what GET, HEAD and DELETE requests sign
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+/*
+struct for storing uploads in an S3 bucket
+  - Endpoint: base URL of the S3 API, e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+  - Region: region the bucket is in, used for signing
+  - Bucket: name of the bucket
+  - AccessKey, SecretKey: credentials to sign requests with
+  - PathStyle: whether the bucket goes into the path instead of the host name
+  - Client: the client requests are sent with
+*/
+type S3Storage struct {
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+	Client    *http.Client
+}
+
+func NewS3Storage(endpoint, region, bucket, accessKey, secretKey string, pathStyle bool) (*S3Storage, error) {
+	if endpoint == "" || bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY all have to be set")
+	}
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT of %s", endpoint)
+	}
+
+	return &S3Storage{
+		Endpoint:  parsed,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PathStyle: pathStyle,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// URL of the object under name, or of the bucket itself for an empty name
+func (s *S3Storage) objectURL(name string, query url.Values) *url.URL {
+	u := *s.Endpoint
+	basePath := strings.TrimSuffix(u.Path, "/")
+
+	if s.PathStyle {
+		u.Path = basePath + "/" + s.Bucket + "/" + name
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = basePath + "/" + name
+	}
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+
+	return &u
+}
+
+func (s *S3Storage) do(method, name string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(name, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+		req.ContentLength = int64(len(body))
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	signS3Request(req, payloadHash, s.AccessKey, s.SecretKey, s.Region, time.Now())
+	return s.Client.Do(req)
+}
+
+// turns a response that isn't a success into an error, with S3's own error code if it sent one
+func s3Error(res *http.Response) error {
+	if res.StatusCode == http.StatusNotFound {
+		return ErrUploadNotFound
+	}
+
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		return fmt.Errorf("s3: %s: %s (%s)", res.Status, body.Code, body.Message)
+	}
+
+	return fmt.Errorf("s3: %s", res.Status)
+}
+
+func storedFileFromHeader(name string, header http.Header) StoredFile {
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	modTime, _ := http.ParseTime(header.Get("Last-Modified"))
+
+	return StoredFile{
+		Name:        name,
+		Size:        size,
+		ModTime:     modTime,
+		ContentType: header.Get("Content-Type"),
+	}
+}
+
+func (s *S3Storage) Put(name string, data []byte) error {
+	if !validUploadName(name) {
+		return fmt.Errorf("invalid upload name %q", name)
+	}
+
+	res, err := s.do(http.MethodPut, name, nil, data, http.DetectContentType(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return s3Error(res)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(name string) (io.ReadCloser, StoredFile, error) {
+	if !validUploadName(name) {
+		return nil, StoredFile{}, ErrUploadNotFound
+	}
+
+	res, err := s.do(http.MethodGet, name, nil, nil, "")
+	if err != nil {
+		return nil, StoredFile{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, StoredFile{}, s3Error(res)
+	}
+
+	return res.Body, storedFileFromHeader(name, res.Header), nil
+}
+
+func (s *S3Storage) Delete(name string) error {
+	if !validUploadName(name) {
+		return fmt.Errorf("invalid upload name %q", name)
+	}
+
+	res, err := s.do(http.MethodDelete, name, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// S3 answers 204 whether or not there was anything to delete
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return s3Error(res)
+	}
+	return nil
+}
+
+func (s *S3Storage) Stat(name string) (StoredFile, error) {
+	if !validUploadName(name) {
+		return StoredFile{}, ErrUploadNotFound
+	}
+
+	res, err := s.do(http.MethodHead, name, nil, nil, "")
+	if err != nil {
+		return StoredFile{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return StoredFile{}, s3Error(res)
+	}
+
+	return storedFileFromHeader(name, res.Header), nil
+}
+
+// lists the bucket with ListObjectsV2, a page of at most 1000 files at a time
+func (s *S3Storage) List() ([]StoredFile, error) {
+	var files []StoredFile
+	continuation := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if continuation != "" {
+			query.Set("continuation-token", continuation)
+		}
+
+		res, err := s.do(http.MethodGet, "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			err := s3Error(res)
+			res.Body.Close()
+			return nil, err
+		}
+
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			// anything in "subdirectories" isn't ours, uploads are flat
+			if !validUploadName(object.Key) {
+				continue
+			}
+			files = append(files, StoredFile{Name: object.Key, Size: object.Size, ModTime: object.LastModified})
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return files, nil
+		}
+		continuation = page.NextContinuationToken
+	}
+}
+
+/*
+URI-encodes s the way signature version 4 wants it: everything but the unreserved characters
+(A-Z a-z 0-9 - _ . ~) gets percent-encoded, and "/" too unless it's a path being encoded
+*/
+func s3URIEncode(s string, isPath bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && isPath:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+/*
+signs req with AWS signature version 4, setting its x-amz-date, x-amz-content-sha256 and Authorization
+headers. every header already on req (and the host) is signed along with it
+*/
+func signS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	query := req.URL.Query()
+	queryKeys := make([]string, 0, len(query))
+	for key := range query {
+		queryKeys = append(queryKeys, key)
+	}
+	sort.Strings(queryKeys)
+
+	var canonicalQuery []string
+	for _, key := range queryKeys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			canonicalQuery = append(canonicalQuery, s3URIEncode(key, false)+"="+s3URIEncode(value, false))
+		}
+	}
+
+	canonicalPath := req.URL.Path
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(canonicalPath, true),
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + s3Service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, s3Service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
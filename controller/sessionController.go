@@ -0,0 +1,331 @@
+package controller
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+	anything related to sessions lives here: creating, looking up and sweeping the sessions that are
+	stored in the "sessions" table.
+
+	sessions used to live in an in-memory map, which meant that every restart of the server logged out
+	everyone on the board. keeping them in the database lets us restart for deploys without kicking
+	everybody off
+
+	every handler goroutine goes through the SessionStore, so anything implementing it has to be safe
+	for concurrent use. the sql-backed store keeps a cache of looked-up sessions in front of the table
+	(so not every request needs a query) guarded by a RWMutex.
+
+	NOTE: a session read from the table (or just inserted into it) used to be put into the cache no
+	matter what happened in between, so a Revoke() / RevokeUser() landing right then left a revoked
+	session valid from the cache until the next restart. every revocation now bumps revocations once
+	the rows are gone from the table, and sessions only go into the cache if there was none since
+	they were read. bumping it any earlier lets a lookup take the new count and still find the row
+*/
+
+const sessionLifetime = 86400 * time.Second
+
+/*
+struct for session-related data stored per session token
+  - Token: the session token itself, as handed out in the "userSessionToken" cookie
+  - Username: Username of the account the session belongs to
+  - Expiry: time after which the session is no longer valid and gets swept
+*/
+type SessionData struct {
+	Token    string
+	Username string
+	Expiry   time.Time
+}
+
+/*
+interface for anything that can hold sessions
+  - Create: creates a new session for username and returns it
+  - Lookup: returns the session of a token, false if it doesn't exist or has expired
+  - Touch: extends the expiry of a session, false if it doesn't exist or has expired
+  - Revoke: removes a single session
+  - RevokeUser: removes every session of username (in any case), returning how many were removed
+  - ListByUser: returns every active session of username (in any case)
+  - DeleteExpired: removes every expired session, returning how many were removed
+*/
+type SessionStore interface {
+	Create(username string) (SessionData, error)
+	Lookup(token string) (SessionData, bool)
+	Touch(token string) bool
+	Revoke(token string) error
+	RevokeUser(username string) (int64, error)
+	ListByUser(username string) ([]SessionData, error)
+	DeleteExpired() (int64, error)
+}
+
+var Sessions SessionStore
+
+type sqlSessionStore struct {
+	db          *sql.DB
+	mu          sync.RWMutex
+	cache       map[string]SessionData
+	revocations uint64
+
+	// called by Lookup() between reading a session from the table and caching it, only set by tests
+	beforeCache func(token string)
+}
+
+func NewSQLSessionStore(database *sql.DB) SessionStore {
+	return &sqlSessionStore{
+		db:    database,
+		cache: make(map[string]SessionData),
+	}
+}
+
+// how many revocations there have been so far, to pass to cacheUnlessRevoked() later
+func (s *sqlSessionStore) revocationCount() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.revocations
+}
+
+// puts session into the cache, unless anything was revoked since revocations was taken
+func (s *sqlSessionStore) cacheUnlessRevoked(session SessionData, revocations uint64) {
+	s.mu.Lock()
+	if s.revocations == revocations {
+		s.cache[session.Token] = session
+	}
+	s.mu.Unlock()
+}
+
+func (s *sqlSessionStore) Create(username string) (SessionData, error) {
+	revocations := s.revocationCount()
+	session := SessionData{
+		Token:    uuid.NewString(),
+		Username: username,
+		Expiry:   time.Now().Add(sessionLifetime),
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO sessions (token, username, expiry)
+		VALUES (?, ?, ?)
+	`, session.Token, session.Username, session.Expiry.Unix())
+	if err != nil {
+		return SessionData{}, err
+	}
+
+	s.cacheUnlessRevoked(session, revocations)
+
+	return session, nil
+}
+
+/*
+expired sessions found during a lookup are removed right away instead of waiting for the sweeper
+*/
+func (s *sqlSessionStore) Lookup(token string) (SessionData, bool) {
+	s.mu.RLock()
+	session, ok := s.cache[token]
+	s.mu.RUnlock()
+
+	if !ok {
+		revocations := s.revocationCount()
+
+		var expiry int64
+		err := s.db.QueryRow(`
+			SELECT username, expiry FROM sessions WHERE token = ?
+		`, token).Scan(&session.Username, &expiry)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Error looking up session: %v\n", err)
+			}
+			return SessionData{}, false
+		}
+
+		session.Token = token
+		session.Expiry = time.Unix(expiry, 0)
+
+		if s.beforeCache != nil {
+			s.beforeCache(token)
+		}
+		s.cacheUnlessRevoked(session, revocations)
+	}
+
+	if time.Now().After(session.Expiry) {
+		s.Revoke(token)
+		return SessionData{}, false
+	}
+
+	return session, true
+}
+
+func (s *sqlSessionStore) Touch(token string) bool {
+	session, ok := s.Lookup(token)
+	if !ok {
+		return false
+	}
+
+	session.Expiry = time.Now().Add(sessionLifetime)
+	_, err := s.db.Exec(`UPDATE sessions SET expiry = ? WHERE token = ?`, session.Expiry.Unix(), token)
+	if err != nil {
+		log.Printf("Error touching session: %v\n", err)
+		return false
+	}
+
+	s.mu.Lock()
+	if _, ok := s.cache[token]; ok {
+		s.cache[token] = session
+	}
+	s.mu.Unlock()
+
+	return true
+}
+
+// the row goes first, then the cache (see the note at the top for why in this order)
+func (s *sqlSessionStore) Revoke(token string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token = ?`, token)
+
+	s.mu.Lock()
+	delete(s.cache, token)
+	s.revocations++
+	s.mu.Unlock()
+
+	return err
+}
+
+func (s *sqlSessionStore) RevokeUser(username string) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE username = ? COLLATE NOCASE`, username)
+
+	s.mu.Lock()
+	for token, session := range s.cache {
+		if strings.EqualFold(session.Username, username) {
+			delete(s.cache, token)
+		}
+	}
+	s.revocations++
+	s.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlSessionStore) ListByUser(username string) ([]SessionData, error) {
+	rows, err := s.db.Query(`
+		SELECT token, expiry FROM sessions WHERE username = ? COLLATE NOCASE AND expiry > ?
+	`, username, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []SessionData
+	for rows.Next() {
+		session := SessionData{Username: username}
+		var expiry int64
+
+		if err := rows.Scan(&session.Token, &expiry); err != nil {
+			return nil, err
+		}
+
+		session.Expiry = time.Unix(expiry, 0)
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (s *sqlSessionStore) DeleteExpired() (int64, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	for token, session := range s.cache {
+		if now.After(session.Expiry) {
+			delete(s.cache, token)
+		}
+	}
+	s.mu.Unlock()
+
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE expiry <= ?`, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func CreateSession(username string) (SessionData, error) {
+	return Sessions.Create(username)
+}
+
+func LookupSession(sessionToken string) (SessionData, bool) {
+	return Sessions.Lookup(sessionToken)
+}
+
+/*
+slides the expiry of a session forward while it's being used, only writing to the database once less
+than half of its lifetime is left so that not every request turns into an UPDATE
+*/
+func TouchSession(session SessionData) {
+	if time.Until(session.Expiry) > sessionLifetime/2 {
+		return
+	}
+
+	Sessions.Touch(session.Token)
+}
+
+func RevokeSession(sessionToken string) error {
+	return Sessions.Revoke(sessionToken)
+}
+
+// removes every session belonging to username, returning how many were revoked
+func RevokeUserSessions(username string) (int64, error) {
+	return Sessions.RevokeUser(username)
+}
+
+// removes every session belonging to username apart from keepToken, returning how many were revoked
+func RevokeOtherUserSessions(username, keepToken string) (int64, error) {
+	sessions, err := Sessions.ListByUser(username)
+	if err != nil {
+		return 0, err
+	}
+
+	var revoked int64
+	for _, session := range sessions {
+		if session.Token == keepToken {
+			continue
+		}
+
+		if err := Sessions.Revoke(session.Token); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+func DeleteExpiredSessions() (int64, error) {
+	return Sessions.DeleteExpired()
+}
+
+// runs DeleteExpiredSessions() in the background every interval for as long as the server is up
+func StartSessionSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			removed, err := DeleteExpiredSessions()
+			if err != nil {
+				log.Printf("Error sweeping expired sessions: %v\n", err)
+				continue
+			}
+
+			if removed > 0 {
+				fmt.Printf("Swept %d expired session(s)\n", removed)
+			}
+		}
+	}()
+}
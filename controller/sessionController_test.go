@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// whether the sessions table still has a row for token, behind the back of the cache
+func sessionRowExists(t *testing.T, token string) bool {
+	t.Helper()
+
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM sessions WHERE token = ?)`, token).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+/*
+every store method at once from a bunch of goroutines, meant for -race. afterwards, whatever Lookup()
+still accepts has to still be in the table: a revoked session must not live on in the cache
+*/
+func TestSessionStoreConcurrent(t *testing.T) {
+	openTestDB(t)
+
+	const workers = 16
+	const rounds = 30
+	users := []string{"alice", "bob", "carol", "dave"}
+
+	var mu sync.Mutex
+	var tokens []string
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			username := users[w%len(users)]
+			for i := 0; i < rounds; i++ {
+				session, err := Sessions.Create(username)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				tokens = append(tokens, session.Token)
+				mu.Unlock()
+
+				if found, ok := Sessions.Lookup(session.Token); ok && found.Username != username {
+					t.Errorf("session of %s looked up as %s", username, found.Username)
+				}
+				Sessions.Touch(session.Token)
+
+				switch i % 5 {
+				case 0:
+					if err := Sessions.Revoke(session.Token); err != nil {
+						t.Error(err)
+					}
+					if _, ok := Sessions.Lookup(session.Token); ok {
+						t.Errorf("session %s still valid after Revoke()", session.Token)
+					}
+				case 1:
+					if _, err := Sessions.RevokeUser(users[(w+1)%len(users)]); err != nil {
+						t.Error(err)
+					}
+				case 2:
+					if _, err := Sessions.ListByUser(username); err != nil {
+						t.Error(err)
+					}
+				case 3:
+					if _, err := Sessions.DeleteExpired(); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, token := range tokens {
+		if _, ok := Sessions.Lookup(token); ok && !sessionRowExists(t, token) {
+			t.Errorf("session %s was revoked but is still accepted from the cache", token)
+		}
+	}
+
+	for _, username := range users {
+		if _, err := Sessions.RevokeUser(username); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, token := range tokens {
+		if _, ok := Sessions.Lookup(token); ok {
+			t.Errorf("session %s still valid after RevokeUser()", token)
+		}
+	}
+}
+
+// a store that never saw a token (like after a restart) has to find it in the table
+func TestSessionStoreLookupFromTable(t *testing.T) {
+	openTestDB(t)
+
+	session, err := Sessions.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Sessions = NewSQLSessionStore(db)
+	found, ok := Sessions.Lookup(session.Token)
+	if !ok || found.Username != "alice" {
+		t.Fatalf("Lookup() after restart = %v, %v", found, ok)
+	}
+}
+
+func loginRequest(username, password string) *http.Request {
+	body := fmt.Sprintf(`{"username": %q, "password": %q}`, username, password)
+	return httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(body))
+}
+
+// logs in and out through the handlers from many goroutines at once, each with its own session
+func TestLoginLogoutConcurrent(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+
+	const clients = 12
+
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+
+			rec := httptest.NewRecorder()
+			Login(rec, loginRequest("alice", "correct horse"))
+			if rec.Code != http.StatusOK {
+				t.Errorf("Login() = %d: %s", rec.Code, rec.Body.String())
+				return
+			}
+
+			var cookie *http.Cookie
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == "userSessionToken" {
+					cookie = c
+				}
+			}
+			if cookie == nil {
+				t.Error("Login() set no session cookie")
+				return
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(cookie)
+			if username := GetUsernameFromCookie(req, "userSessionToken"); username != "alice" {
+				t.Errorf("session of a fresh login belongs to %q", username)
+			}
+
+			// half of them log out again, the other half stays logged in
+			if c%2 == 1 {
+				return
+			}
+
+			rec = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodPost, "/api/logout", nil)
+			req.AddCookie(cookie)
+			Logout(rec, req)
+
+			var res map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || res["status"] != "logged_out" {
+				t.Errorf("Logout() = %d %v", rec.Code, res)
+			}
+
+			if _, ok := LookupSession(cookie.Value); ok {
+				t.Error("session still valid after Logout()")
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	sessions, err := Sessions.ListByUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != clients/2 {
+		t.Errorf("%d session(s) left after half of %d clients logged out", len(sessions), clients)
+	}
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+
+	rec := httptest.NewRecorder()
+	Login(rec, loginRequest("alice", "wrong horse"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Login() with the wrong password = %d", rec.Code)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Fatal("Login() with the wrong password set a cookie")
+	}
+}
+
+// a cache miss for token on a fresh store, so Lookup() has to go to the table
+func freshSessionStore(t *testing.T) *sqlSessionStore {
+	t.Helper()
+
+	store, ok := NewSQLSessionStore(db).(*sqlSessionStore)
+	if !ok {
+		t.Fatal("NewSQLSessionStore() isn't a *sqlSessionStore")
+	}
+	return store
+}
+
+/*
+a revocation landing after Lookup() has read the row but before it caches it: the session is gone
+for good once Revoke() / RevokeUser() returned, not kept alive by the cache
+*/
+func TestSessionLookupRevokedBeforeCaching(t *testing.T) {
+	openTestDB(t)
+
+	for name, revoke := range map[string]func(store *sqlSessionStore, session SessionData) error{
+		"Revoke": func(store *sqlSessionStore, session SessionData) error {
+			return store.Revoke(session.Token)
+		},
+		"RevokeUser": func(store *sqlSessionStore, session SessionData) error {
+			_, err := store.RevokeUser(session.Username)
+			return err
+		},
+	} {
+		session, err := Sessions.Create("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store := freshSessionStore(t)
+		store.beforeCache = func(string) {
+			store.beforeCache = nil
+			if err := revoke(store, session); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		store.Lookup(session.Token)
+		if _, ok := store.Lookup(session.Token); ok {
+			t.Errorf("%s: session still valid after being revoked during a lookup", name)
+		}
+	}
+}
+
+/*
+a lookup while Revoke() is still waiting to delete the row: the row is still there, so the lookup
+may find it, but Revoke() mustn't let it stay in the cache after it returned. a transaction holding
+the write lock keeps Revoke() waiting until the lookup is done
+*/
+func TestSessionLookupDuringRevoke(t *testing.T) {
+	openTestDB(t)
+
+	session, err := Sessions.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := freshSessionStore(t)
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked := make(chan error)
+	go func() { revoked <- store.Revoke(session.Token) }()
+
+	// Revoke() is waiting on the write lock once it has a connection of its own
+	for db.Stats().InUse < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, ok := store.Lookup(session.Token); !ok {
+		t.Fatal("session not found while Revoke() is still waiting")
+	}
+
+	if _, err := conn.ExecContext(ctx, `ROLLBACK`); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-revoked; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.Lookup(session.Token); ok {
+		t.Fatal("session still valid from the cache after Revoke() returned")
+	}
+}
@@ -2,72 +2,93 @@ package controller
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 var db *sql.DB
 
-func OpenSQL() {
+// opens the connection to mmiv.db without touching the schema
+func ConnectSQL() {
 	var err error
-	db, err = sql.Open("sqlite3", "./mmiv.db")
+	// busy_timeout makes concurrent writers from different handlers wait for the lock instead of failing
+	db, err = sql.Open("sqlite3", "./mmiv.db?_busy_timeout=5000")
 	if err != nil {
 		log.Fatal(err)
 	}
+	Sessions = NewSQLSessionStore(db)
+
+	if err = db.Ping(); err != nil {
+		log.Fatal("Cannot connect to database:", err)
+	}
+}
+
+// opens the connection to mmiv.db and brings the schema up to date, see migrationController.go
+func OpenSQL() {
+	ConnectSQL()
+
+	if err := MigrateSQL(); err != nil {
+		if IsUniqueConstraintError(err) {
+			PrintDuplicateUsernames()
+		}
+		log.Fatal("Cannot migrate database:", err)
+	}
 
-	WriteToSQL(`
-		CREATE TABLE IF NOT EXISTS posts (
-		id INTEGER PRIMARY KEY,
-		username TEXT NOT NULL,
-		postcontent TEXT NOT NULL,
-		imagepath TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		pinned INTEGER NOT NULL DEFAULT 0,
-		locked INTEGER NOT NULL DEFAULT 0,
-		isanonymous INTEGER NOT NULL DEFAULT 0
-	)`)
-
-	WriteToSQL(`
-		CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT NOT NULL,
-		password TEXT NOT NULL,
-		rank INTEGER NOT NULL
-	)`)
-
-	WriteToSQL(`
-		CREATE TABLE IF NOT EXISTS comments (
-		id INTEGER PRIMARY KEY,
-		parentpostid INTEGER NOT NULL,
-		username TEXT NOT NULL,
-		postcontent TEXT NOT NULL,
-		imagepath TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		isanonymous INTEGER NOT NULL DEFAULT 0
-	)`)
-
-	WriteToSQL(`
-		CREATE TABLE IF NOT EXISTS announcements (
-		id INTEGER PRIMARY KEY CHECK (id = 1),
-		content TEXT NOT NULL,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`)
-
-	WriteToSQL(`
-		CREATE TABLE IF NOT EXISTS emoticons (
-		name TEXT NOT NULL
-	)`)
 	LoadEmoticonsFromDB()
 
-	WriteToSQL(`
-		CREATE TABLE IF NOT EXISTS global_ids (
-		id INTEGER PRIMARY KEY AUTOINCREMENT
-	)`)
+	if err := LoadPermissionsFromDB(); err != nil {
+		log.Fatal("Cannot load role permissions:", err)
+	}
 
-	if err = db.Ping(); err != nil {
-		log.Fatal("Cannot connect to database:", err)
+	if err := LoadBansFromDB(); err != nil {
+		log.Fatal("Cannot load bans:", err)
+	}
+}
+
+/*
+prints every group of usernames that only differ in case, which keep the unique index of migration
+0014_unique_usernames from being created until they're renamed or deleted
+*/
+func PrintDuplicateUsernames() {
+	rows, err := db.Query(`
+		SELECT GROUP_CONCAT(id || ':' || username, ', ')
+		FROM users
+		GROUP BY username COLLATE NOCASE
+		HAVING COUNT(*) > 1
+	`)
+	if err != nil {
+		log.Printf("Error checking for duplicate usernames: %v\n", err)
+		return
+	}
+	defer rows.Close()
+
+	var duplicates []string
+	for rows.Next() {
+		var group string
+		if err := rows.Scan(&group); err != nil {
+			log.Printf("Error scanning duplicate usernames: %v\n", err)
+			continue
+		}
+		duplicates = append(duplicates, group)
 	}
+
+	if len(duplicates) > 0 {
+		fmt.Println("Found duplicate usernames, rename or delete them before migrating:")
+		fmt.Printf("  (id:username) %s\n", strings.Join(duplicates, "\n  (id:username) "))
+	}
+}
+
+func IsUniqueConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+
+	return false
 }
 
 func WriteToSQL(execString string, args ...interface{}) error {
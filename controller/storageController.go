@@ -0,0 +1,270 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	where uploaded files actually live. everything that touches upload files goes through the Storage
+	in Uploads, so whether they're on this machine or in an S3 bucket is only a matter of Cfg.Storage:
+	  - "local": the uploads/ directory next to the server (LocalStorage)
+	  - "s3": a bucket on S3 or anything speaking its API, like MinIO (S3Storage, see s3Controller.go)
+
+	files are addressed by their name alone, which is the imagepath / thumbpath stored in posts and
+	comments without the "uploads/" in front (uploadName()). uploads/ is flat, there are no directories
+*/
+
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+/*
+struct for what a Storage knows about a stored file
+  - Name: name of the file
+  - Size: size in bytes
+  - ModTime: when the file was last written
+  - ContentType: MIME type of the file, can be empty if the storage doesn't know
+*/
+type StoredFile struct {
+	Name        string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+/*
+what every storage backend for uploads has to be able to do. every method returns ErrUploadNotFound
+(possibly wrapped) for files that don't exist, except Delete() which doesn't mind
+  - Put: stores data under name, replacing whatever was there
+  - Get: opens the file under name, the caller has to close it
+  - Delete: removes the file under name
+  - Stat: returns information about the file under name
+  - List: returns every stored file
+*/
+type Storage interface {
+	Put(name string, data []byte) error
+	Get(name string) (io.ReadCloser, StoredFile, error)
+	Delete(name string) error
+	Stat(name string) (StoredFile, error)
+	List() ([]StoredFile, error)
+}
+
+var Uploads Storage
+
+// sets up Uploads according to Cfg.Storage, every upload goes through it from then on
+func OpenStorage() {
+	switch Cfg.Storage {
+	case StorageS3:
+		storage, err := NewS3Storage(Cfg.S3Endpoint, Cfg.S3Region, Cfg.S3Bucket, Cfg.S3AccessKey, Cfg.S3SecretKey, Cfg.S3PathStyle)
+		if err != nil {
+			log.Fatal("Cannot set up S3 storage: ", err)
+		}
+		Uploads = storage
+	default:
+		if err := os.MkdirAll(UploadsDir, 0755); err != nil {
+			log.Fatal("Cannot create uploads directory: ", err)
+		}
+		Uploads = LocalStorage{Dir: UploadsDir}
+	}
+}
+
+// the name an imagepath / thumbpath stored in posts and comments has in the storage
+func uploadName(imagePath string) string {
+	return path.Base(filepath.ToSlash(NormalizeUploadPath(imagePath)))
+}
+
+// the imagepath / thumbpath a file with name in the storage gets in posts and comments
+func uploadPath(name string) string {
+	return UploadsDir + "/" + name
+}
+
+// names have to be a plain file name, so nothing can reach outside of where the uploads are stored
+func validUploadName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+/*
+serves the upload under name, returning false if there's no such file. range requests and
+If-Modified-Since work for stores that can seek (local), the rest is sent as a whole
+*/
+func ServeUpload(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !validUploadName(name) {
+		return false
+	}
+
+	file, info, err := Uploads.Get(name)
+	if errors.Is(err, ErrUploadNotFound) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Error opening upload %s: %v\n", name, err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return true
+	}
+	defer file.Close()
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, info.ModTime, seeker)
+		return true
+	}
+
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if info.Size > 0 {
+		w.Header().Set("Content-Length", fmt.Sprint(info.Size))
+	}
+	io.Copy(w, file)
+	return true
+}
+
+// lists every stored upload as links, for those allowed to look at the raw uploads directory
+func ServeUploadListing(w http.ResponseWriter) {
+	files, err := Uploads.List()
+	if err != nil {
+		log.Printf("Error listing uploads: %v\n", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<pre>")
+	for _, file := range files {
+		link := url.URL{Path: file.Name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(file.Name))
+	}
+	fmt.Fprintln(w, "</pre>")
+}
+
+// files Put() is still writing end in this, they aren't uploads (yet) and List() leaves them out
+const localTempSuffix = ".tmp"
+
+/*
+struct for storing uploads in a directory on this machine
+  - Dir: the directory everything is stored in
+*/
+type LocalStorage struct {
+	Dir string
+}
+
+func (s LocalStorage) path(name string) (string, error) {
+	if !validUploadName(name) {
+		return "", fmt.Errorf("invalid upload name %q", name)
+	}
+	return filepath.Join(s.Dir, name), nil
+}
+
+func (s LocalStorage) Put(name string, data []byte) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	// written next to it first, so a half-written file never ends up under the final name
+	tmpPath := path + localTempSuffix
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (s LocalStorage) Get(name string) (io.ReadCloser, StoredFile, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, StoredFile{}, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, StoredFile{}, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, StoredFile{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, StoredFile{}, ErrUploadNotFound
+	}
+
+	return file, StoredFile{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s LocalStorage) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s LocalStorage) Stat(name string) (StoredFile, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return StoredFile{}, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return StoredFile{}, ErrUploadNotFound
+	}
+	if err != nil {
+		return StoredFile{}, err
+	}
+
+	return StoredFile{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s LocalStorage) List() ([]StoredFile, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []StoredFile
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), localTempSuffix) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		files = append(files, StoredFile{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+
+	return files, nil
+}
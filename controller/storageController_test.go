@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a file Put() is still in the middle of writing isn't an upload yet
+func TestLocalStorageListSkipsTemporaryFiles(t *testing.T) {
+	storage := LocalStorage{Dir: t.TempDir()}
+
+	if err := storage.Put("done.png", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(storage.Dir, "writing.png"+localTempSuffix), []byte("da"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := storage.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "done.png" || files[0].Size != 4 {
+		t.Fatalf("List() = %+v", files)
+	}
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+	shared setup for the tests of this package: every test gets its own mmiv.db in a temporary
+	directory, migrated like OpenSQL() would, with the package globals (db, Sessions, Cfg, Uploads)
+	pointed at it and a login throttle of its own
+*/
+
+// opens a fresh, fully migrated database for the length of the test
+func openTestDB(t testing.TB) {
+	t.Helper()
+
+	// the real cost takes about a second per hash, which adds up quickly (especially under -race)
+	passwordHashCost = bcrypt.MinCost
+
+	database, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "mmiv.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	db = database
+	Sessions = NewSQLSessionStore(db)
+	LoginThrottler = NewLoginThrottle()
+	LoadConfig()
+	Uploads = LocalStorage{Dir: t.TempDir()}
+
+	if err := MigrateSQL(); err != nil {
+		t.Fatal("migrating test database: ", err)
+	}
+	if err := LoadPermissionsFromDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadBansFromDB(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// adds an account to the test database
+func addTestUser(t testing.TB, username, password, role string) {
+	t.Helper()
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`INSERT INTO users (username, password, role) VALUES (?, ?, ?)`, username, hash, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,316 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	brute-force protection for Login(): failed attempts are tracked per account and per client IP, once
+	either goes past its amount of free attempts it gets locked out with an exponential backoff
+	(30s, 1m, 2m, ... up to an hour) for every further failure.
+
+	while locked out Login() answers with a 429 and a Retry-After header without even checking the
+	password. every lockout is written to "login_lockouts" so admins can review them on the dashboard.
+
+	checking a password takes a while (bcrypt), so an attempt is reserved with ReserveAttempt() before
+	it's checked and only settled by RecordFailure() / RecordSuccess() afterwards. attempts still being
+	checked count against the free ones, otherwise a burst of parallel guesses would all get past the
+	check before the first of them failed. once the free attempts are used up, attempts are checked one
+	at a time and the rest wait for the one before them to settle
+
+	the counters themselves only live in memory, a restart resets them which is fine for our purposes
+*/
+
+const (
+	accountFreeAttempts = 5
+	ipFreeAttempts      = 20
+	lockoutBase         = 30 * time.Second
+	lockoutMax          = time.Hour
+
+	// records without a failure for this long are forgotten
+	attemptMemory = 24 * time.Hour
+)
+
+type attemptRecord struct {
+	failures    int
+	pending     int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// a lockout to be written to "login_lockouts", collected under the lock and inserted after it
+type lockoutRow struct {
+	kind        string
+	subject     string
+	failures    int
+	lockedUntil time.Time
+}
+
+type LoginThrottle struct {
+	mu       sync.Mutex
+	settled  *sync.Cond // signalled whenever a reserved attempt is settled
+	accounts map[string]*attemptRecord
+	ips      map[string]*attemptRecord
+}
+
+var LoginThrottler = NewLoginThrottle()
+
+func NewLoginThrottle() *LoginThrottle {
+	t := &LoginThrottle{
+		accounts: make(map[string]*attemptRecord),
+		ips:      make(map[string]*attemptRecord),
+	}
+	t.settled = sync.NewCond(&t.mu)
+	return t
+}
+
+/*
+struct for lockout-related data that we can assemble and serve
+  - Kind: either "account" or "ip", what was locked out
+  - Subject: the username or IP that was locked out
+  - Failures: how many failed attempts caused the lockout
+  - LockedUntil: timestamp of when the lockout ends
+  - CreatedAt: timestamp of when the lockout started
+*/
+type LockoutData struct {
+	Kind        string `json:"kind"`
+	Subject     string `json:"subject"`
+	Failures    int    `json:"failures"`
+	LockedUntil string `json:"lockeduntil"`
+	CreatedAt   string `json:"createdat"`
+}
+
+func lockoutDuration(failures, freeAttempts int) time.Duration {
+	over := failures - freeAttempts
+	if over <= 0 {
+		return 0
+	}
+
+	duration := lockoutBase * time.Duration(math.Pow(2, float64(over-1)))
+	if duration > lockoutMax || duration <= 0 {
+		return lockoutMax
+	}
+
+	return duration
+}
+
+// how long record is still locked out for, 0 if it isn't
+func (record *attemptRecord) lockedFor(now time.Time) time.Duration {
+	if record.lockedUntil.After(now) {
+		return record.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// whether another attempt has to wait for the ones being checked, as they'd use up the free attempts
+func (record *attemptRecord) busy(freeAttempts int) bool {
+	return record.pending > 0 && record.failures+record.pending >= freeAttempts
+}
+
+// the record of subject, created if there's none yet
+func getRecord(records map[string]*attemptRecord, subject string) *attemptRecord {
+	record, ok := records[subject]
+	if !ok {
+		record = &attemptRecord{}
+		records[subject] = record
+	}
+
+	return record
+}
+
+// settles one reserved attempt of subject and returns its record
+func releaseRecord(records map[string]*attemptRecord, subject string) *attemptRecord {
+	record := getRecord(records, subject)
+	record.pending = max(record.pending-1, 0)
+	return record
+}
+
+/*
+returns how long username / ip are still locked out for, 0 if they aren't. in that case the attempt
+is reserved and has to be settled with RecordFailure() or RecordSuccess() once the password is checked.
+blocks while the attempts already being checked would use up the free ones
+*/
+func (t *LoginThrottle) ReserveAttempt(username, ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	account := strings.ToLower(username)
+	for {
+		now := time.Now()
+		var wait time.Duration
+		busy := false
+
+		if record, ok := t.accounts[account]; ok {
+			wait = record.lockedFor(now)
+			busy = record.busy(accountFreeAttempts)
+		}
+
+		if record, ok := t.ips[ip]; ok {
+			wait = max(wait, record.lockedFor(now))
+			busy = busy || record.busy(ipFreeAttempts)
+		}
+
+		if wait > 0 {
+			return wait
+		}
+		if !busy {
+			break
+		}
+
+		t.settled.Wait()
+	}
+
+	getRecord(t.accounts, account).pending++
+	getRecord(t.ips, ip).pending++
+	return 0
+}
+
+func (t *LoginThrottle) recordFailure(records map[string]*attemptRecord, kind, subject string, freeAttempts int) *lockoutRow {
+	now := time.Now()
+
+	record := releaseRecord(records, subject)
+	record.failures++
+	record.lastFailure = now
+
+	duration := lockoutDuration(record.failures, freeAttempts)
+	if duration == 0 {
+		return nil
+	}
+
+	record.lockedUntil = now.Add(duration)
+	fmt.Printf("Login lockout of %s %s for %s after %d failed attempts\n", kind, subject, duration, record.failures)
+
+	return &lockoutRow{kind, subject, record.failures, record.lockedUntil}
+}
+
+// settles an attempt reserved with ReserveAttempt() whose password was wrong
+func (t *LoginThrottle) RecordFailure(username, ip string) {
+	t.mu.Lock()
+	lockouts := []*lockoutRow{
+		t.recordFailure(t.accounts, "account", strings.ToLower(username), accountFreeAttempts),
+		t.recordFailure(t.ips, "ip", ip, ipFreeAttempts),
+	}
+	t.settled.Broadcast()
+	t.mu.Unlock()
+
+	for _, lockout := range lockouts {
+		if lockout == nil {
+			continue
+		}
+
+		err := WriteToSQL(`
+			INSERT INTO login_lockouts (kind, subject, failures, locked_until)
+			VALUES (?, ?, ?, ?)
+		`, lockout.kind, lockout.subject, lockout.failures, lockout.lockedUntil.Unix())
+		if err != nil {
+			log.Printf("Error logging login lockout: %v\n", err)
+		}
+	}
+}
+
+/*
+settles an attempt reserved with ReserveAttempt() whose password was right. it only clears the
+account, the IP keeps its failures so that someone who owns a valid account can't use it to reset
+their counter while guessing the passwords of others
+*/
+func (t *LoginThrottle) RecordSuccess(username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	subject := strings.ToLower(username)
+	if account := releaseRecord(t.accounts, subject); account.pending > 0 {
+		// other attempts on the account are still being checked and settle this record later
+		account.failures = 0
+		account.lockedUntil = time.Time{}
+	} else {
+		delete(t.accounts, subject)
+	}
+
+	releaseRecord(t.ips, ip)
+	t.settled.Broadcast()
+}
+
+func (t *LoginThrottle) Prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for _, records := range []map[string]*attemptRecord{t.accounts, t.ips} {
+		for subject, record := range records {
+			if record.pending == 0 && record.lockedUntil.Before(now) && now.Sub(record.lastFailure) > attemptMemory {
+				delete(records, subject)
+			}
+		}
+	}
+}
+
+// runs Prune() in the background every interval for as long as the server is up
+func StartLoginThrottlePruner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			LoginThrottler.Prune()
+		}
+	}()
+}
+
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":     "error",
+		"error":      message,
+		"retryafter": strconv.Itoa(seconds),
+	})
+}
+
+func RequestLockouts(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch in RequestLockouts, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to view lockouts!")
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT kind, subject, failures, locked_until, created_at
+		FROM login_lockouts
+		ORDER BY id DESC
+		LIMIT 100
+	`)
+	if err != nil {
+		log.Printf("Error querying lockouts: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer rows.Close()
+
+	lockouts := []LockoutData{}
+	for rows.Next() {
+		var lockout LockoutData
+		var lockedUntil int64
+
+		err := rows.Scan(&lockout.Kind, &lockout.Subject, &lockout.Failures, &lockedUntil, &lockout.CreatedAt)
+		if err != nil {
+			log.Printf("Error scanning lockout: %v\n", err)
+			continue
+		}
+
+		lockout.LockedUntil = time.Unix(lockedUntil, 0).UTC().Format(time.RFC3339)
+		lockouts = append(lockouts, lockout)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lockouts)
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// attempts still being checked count against the free ones
+func TestReserveAttemptInFlight(t *testing.T) {
+	openTestDB(t)
+	throttle := NewLoginThrottle()
+
+	for i := 0; i < accountFreeAttempts; i++ {
+		if wait := throttle.ReserveAttempt("alice", fmt.Sprintf("192.0.2.%d", i)); wait != 0 {
+			t.Fatalf("attempt %d has to wait %s", i, wait)
+		}
+	}
+
+	// the next one waits for those to settle, a failure uses its attempt up and a success gives them back
+	reserved := make(chan time.Duration)
+	go func() { reserved <- throttle.ReserveAttempt("Alice", "198.51.100.1") }()
+
+	throttle.RecordFailure("alice", "192.0.2.0")
+	select {
+	case wait := <-reserved:
+		t.Fatalf("attempt past the free ones got through after a failure (wait %s)", wait)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	throttle.RecordSuccess("alice", "192.0.2.1")
+	if wait := <-reserved; wait != 0 {
+		t.Fatalf("attempt after a success has to wait %s", wait)
+	}
+
+	for i := 2; i < accountFreeAttempts; i++ {
+		throttle.RecordFailure("alice", fmt.Sprintf("192.0.2.%d", i))
+	}
+	throttle.RecordFailure("alice", "198.51.100.1")
+
+	// 4 failures since the success, the fifth is free and the sixth locks the account out
+	for i := 0; i < 2; i++ {
+		if wait := throttle.ReserveAttempt("alice", "203.0.113.1"); wait != 0 {
+			t.Fatalf("attempt %d past the success has to wait %s", 5+i, wait)
+		}
+		throttle.RecordFailure("alice", "203.0.113.1")
+	}
+	if wait := throttle.ReserveAttempt("alice", "203.0.113.1"); wait <= lockoutBase-time.Second || wait > lockoutBase {
+		t.Fatalf("locked out account has to wait %s, want %s", wait, lockoutBase)
+	}
+
+	var lockouts int
+	db.QueryRow(`SELECT COUNT(*) FROM login_lockouts WHERE kind = 'account' AND subject = 'alice'`).Scan(&lockouts)
+	if lockouts != 1 {
+		t.Fatalf("%d lockout(s) logged, want 1", lockouts)
+	}
+}
+
+// a burst of parallel guesses can't get more than the free attempts checked
+func TestLoginParallelGuesses(t *testing.T) {
+	openTestDB(t)
+	addTestUser(t, "alice", "correct horse", RoleUser)
+
+	const guesses = 30
+
+	var wg sync.WaitGroup
+	codes := make(chan int, guesses)
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			rec := httptest.NewRecorder()
+			Login(rec, loginRequest("alice", fmt.Sprintf("guess %d", i)))
+			codes <- rec.Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+
+	checked := 0
+	for code := range codes {
+		switch code {
+		case http.StatusUnauthorized:
+			checked++
+		case http.StatusTooManyRequests:
+		default:
+			t.Fatalf("Login() = %d", code)
+		}
+	}
+	if checked > accountFreeAttempts+1 {
+		t.Fatalf("%d of %d guesses were checked, want at most %d", checked, guesses, accountFreeAttempts+1)
+	}
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	thumbnails, so the feed doesn't have to download every full-size upload just to show it 150px wide.
+
+	every uploaded image gets a downscaled JPEG stored right next to it in uploads/ (same name with
+	"_thumb.jpg" at the end), and its path goes into the thumbpath column of the post or comment. like
+	the images themselves, thumbnails are reference counted in "uploads" (see uploadController.go).
+	images that are already small enough don't get one, an empty thumbpath means the front-end just
+	shows the original. for GIFs the thumbnail is the first frame, the animation plays once opened.
+	WebPs and video clips can't be decoded (see imageController.go, videoController.go), so they have none
+
+	uploads from before thumbnails existed can be caught up on with -backfill-thumbnails
+*/
+
+const (
+	// longest side of a thumbnail in pixels, twice the width the feed shows them at for sharper screens
+	thumbnailMaxSize = 300
+	thumbnailQuality = 80
+)
+
+// path of the thumbnail belonging to the upload at imagePath
+func thumbnailPath(imagePath string) string {
+	return strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + "_thumb.jpg"
+}
+
+/*
+scales src down to fit in a maxSize by maxSize square, keeping the aspect ratio. every pixel of the
+result is the average of the block of source pixels it covers, which looks a lot better than just
+picking one of them. transparency is flattened onto white since JPEG has none. src is only ever copied
+one band of rows at a time, a full-size copy of a big upload would take more memory than the upload itself
+*/
+func downscaleImage(src image.Image, maxSize int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	dstWidth, dstHeight := width, height
+	if width >= height && width > maxSize {
+		dstWidth, dstHeight = maxSize, max(1, height*maxSize/width)
+	} else if height > width && height > maxSize {
+		dstWidth, dstHeight = max(1, width*maxSize/height), maxSize
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	// the source rows one row of dst covers, flattened onto white
+	band := image.NewRGBA(image.Rect(0, 0, width, (height+dstHeight-1)/dstHeight))
+
+	for y := 0; y < dstHeight; y++ {
+		y0 := y * height / dstHeight
+		y1 := max((y+1)*height/dstHeight, y0+1)
+
+		rows := image.Rect(0, 0, width, y1-y0)
+		draw.Draw(band, rows, image.White, image.Point{}, draw.Src)
+		draw.Draw(band, rows, src, image.Pt(bounds.Min.X, bounds.Min.Y+y0), draw.Over)
+
+		for x := 0; x < dstWidth; x++ {
+			x0 := x * width / dstWidth
+			x1 := max((x+1)*width/dstWidth, x0+1)
+
+			var r, g, b, count int
+			for sy := 0; sy < y1-y0; sy++ {
+				i := band.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(band.Pix[i])
+					g += int(band.Pix[i+1])
+					b += int(band.Pix[i+2])
+					count++
+					i += 4
+				}
+			}
+
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] = uint8(r / count)
+			dst.Pix[o+1] = uint8(g / count)
+			dst.Pix[o+2] = uint8(b / count)
+			dst.Pix[o+3] = 0xff
+		}
+	}
+
+	return dst
+}
+
+/*
+creates the thumbnail of img, the upload at imagePath, returning the path it was saved to. returns an
+empty path without an error when the image is small enough to not need one
+*/
+func CreateThumbnail(img image.Image, imagePath string) (string, error) {
+	if img.Bounds().Dx() <= thumbnailMaxSize && img.Bounds().Dy() <= thumbnailMaxSize {
+		return "", nil
+	}
+
+	var thumbnail bytes.Buffer
+	err := jpeg.Encode(&thumbnail, downscaleImage(img, thumbnailMaxSize), &jpeg.Options{Quality: thumbnailQuality})
+	if err != nil {
+		return "", err
+	}
+
+	thumbPath := thumbnailPath(imagePath)
+	if err := Uploads.Put(uploadName(thumbPath), thumbnail.Bytes()); err != nil {
+		return "", err
+	}
+
+	return thumbPath, nil
+}
+
+// decodes the (first frame of the) upload at imagePath, refusing ones too big to decode safely
+func decodeUploadFile(imagePath string) (image.Image, error) {
+	stored, _, err := Uploads.Get(uploadName(imagePath))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(stored)
+	stored.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	file := bytes.NewReader(data)
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if !withinUploadDimensions(config.Width, config.Height) {
+		return nil, ErrDimensionsTooLarge
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+/*
+creates and saves the thumbnail of img for the post or comment reference points at, returning its path
+(empty when there's none to add). like SaveUpload(), the file, the thumbpath and its reference all go in
+under uploadsMu, so the server can't remove the file in between. only database errors are returned, a
+thumbnail that can't be made is logged and skipped
+*/
+func backfillThumbnail(reference UploadReference, img image.Image) (string, error) {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+
+	thumbPath, err := CreateThumbnail(img, uploadKey(reference.Imagepath))
+	if err != nil {
+		log.Printf("Error creating thumbnail of %s: %v\n", reference.Imagepath, err)
+		return "", nil
+	}
+	if thumbPath == "" {
+		return "", nil
+	}
+
+	table := "posts"
+	if reference.Kind == RevisionKindComment {
+		table = "comments"
+	}
+
+	// the post or comment could be gone by now, then the thumbnail is left for the upload check
+	res, err := db.Exec(`UPDATE `+table+` SET thumbpath = ? WHERE id = ? AND thumbpath = ''`, thumbPath, reference.Id)
+	if err != nil {
+		return "", err
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated == 0 {
+		return "", err
+	}
+
+	return thumbPath, acquireUploads(thumbPath)
+}
+
+/*
+creates thumbnails for every post and comment with an image but no thumbnail yet, returning how many
+were created. uploads that don't need one are skipped (every time, they're cheap to look at), and so
+are the ones that can't have one
+*/
+func BackfillThumbnails() (int, error) {
+	references, err := queryUploadReferences()
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, reference := range references {
+		if reference.Thumbpath != "" || !storedUploadType(reference.Imagepath).Decodable {
+			continue
+		}
+
+		img, err := decodeUploadFile(reference.Imagepath)
+		if err != nil {
+			log.Printf("Error decoding %s for a thumbnail: %v\n", reference.Imagepath, err)
+			continue
+		}
+
+		thumbPath, err := backfillThumbnail(reference, img)
+		if err != nil {
+			return created, err
+		}
+		if thumbPath == "" {
+			continue
+		}
+
+		fmt.Printf("Created thumbnail %s\n", thumbPath)
+		created++
+	}
+
+	return created, nil
+}
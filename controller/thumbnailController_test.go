@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// an image with an opaque red left half and a see-through right half
+func halfRedImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 0xff, A: 0xff})
+		}
+	}
+	return img
+}
+
+func TestDownscaleImage(t *testing.T) {
+	// bounds that don't start at 0,0, like a sub-image
+	src := halfRedImage(1300, 900).SubImage(image.Rect(100, 0, 1300, 800))
+
+	dst := downscaleImage(src, thumbnailMaxSize)
+	if dst.Bounds() != image.Rect(0, 0, 300, 200) {
+		t.Fatalf("downscaled to %v", dst.Bounds())
+	}
+
+	// 100..650 is red in src, so the first 137 columns of the thumbnail are
+	for _, c := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{0xff, 0, 0, 0xff}},
+		{136, 199, color.RGBA{0xff, 0, 0, 0xff}},
+		{138, 100, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{299, 199, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+	} {
+		if got := dst.RGBAAt(c.x, c.y); got != c.want {
+			t.Errorf("pixel %d,%d = %v, want %v", c.x, c.y, got, c.want)
+		}
+	}
+}
+
+func TestBackfillThumbnails(t *testing.T) {
+	openTestDB(t)
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, halfRedImage(800, 600)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Uploads.Put("big.png", encoded.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	imagePath := uploadPath("big.png")
+	_, err := db.Exec(`
+		INSERT INTO posts (id, username, postcontent, imagepath) VALUES (1, 'alice', '', ?), (2, 'bob', '', ?)
+	`, imagePath, imagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := BackfillThumbnails()
+	if err != nil || created != 2 {
+		t.Fatalf("BackfillThumbnails() = %d, %v", created, err)
+	}
+
+	thumbPath := thumbnailPath(imagePath)
+	var refcount int
+	db.QueryRow(`SELECT refcount FROM uploads WHERE path = ?`, uploadKey(thumbPath)).Scan(&refcount)
+	if refcount != 2 {
+		t.Errorf("thumbnail has %d reference(s), want 2", refcount)
+	}
+
+	stored, _, err := Uploads.Get(uploadName(thumbPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stored.Close()
+	config, _, err := image.DecodeConfig(stored)
+	if err != nil || config.Width != 300 || config.Height != 225 {
+		t.Fatalf("thumbnail is %dx%d (%v)", config.Width, config.Height, err)
+	}
+
+	// nothing left to do the second time
+	if created, err := BackfillThumbnails(); err != nil || created != 0 {
+		t.Fatalf("BackfillThumbnails() again = %d, %v", created, err)
+	}
+}
@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+	the trash: DeletePost() and DeleteComment() only set deleted_at, which hides things from
+	RequestPost() / RequestComment() without removing anything. from the dashboard whatever is in the
+	trash can be restored, or purged for good right away.
+
+	everything that's been in the trash for longer than Cfg.TrashRetention gets purged by a background
+	job (StartTrashPurger()), rows and uploaded files alike. a retention of 0 keeps the trash forever
+*/
+
+/*
+struct for trash-related data that we can assemble and serve
+  - Id: ID of the deleted post or comment
+  - Kind: either "post" or "comment"
+  - ParentPostID: ID of the post a comment was under, empty for posts
+  - Username: Username of the person that created it
+  - PostContent: text of the post or comment
+  - Imagepath: local machine path to the image that's being stored
+  - Timestamp: timestamp of when it was submitted
+  - DeletedAt: timestamp of when it was moved to the trash
+  - DeletedBy: username of who deleted it
+*/
+type TrashData struct {
+	Id           string `json:"id"`
+	Kind         string `json:"kind"`
+	ParentPostID string `json:"parentpostid"`
+	Username     string `json:"username"`
+	PostContent  string `json:"postcontent"`
+	Imagepath    string `json:"imagepath"`
+	Timestamp    string `json:"timestamp"`
+	DeletedAt    string `json:"deletedat"`
+	DeletedBy    string `json:"deletedby"`
+}
+
+func RequestTrash(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermDeleteAny) {
+		fmt.Printf("Permission mismatch in RequestTrash, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to view the trash!")
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, ?, '', username, postcontent, imagepath, timestamp, deleted_at, COALESCE(deleted_by, '')
+		FROM posts WHERE deleted_at IS NOT NULL
+		UNION ALL
+		SELECT id, ?, parentpostid, username, postcontent, imagepath, timestamp, deleted_at, COALESCE(deleted_by, '')
+		FROM comments WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+		LIMIT 200
+	`, RevisionKindPost, RevisionKindComment)
+	if err != nil {
+		log.Printf("Error querying trash: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer rows.Close()
+
+	items := []TrashData{}
+	for rows.Next() {
+		var item TrashData
+		err := rows.Scan(
+			&item.Id,
+			&item.Kind,
+			&item.ParentPostID,
+			&item.Username,
+			&item.PostContent,
+			&item.Imagepath,
+			&item.Timestamp,
+			&item.DeletedAt,
+			&item.DeletedBy,
+		)
+		if err != nil {
+			log.Printf("Error scanning trash item: %v\n", err)
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermDeleteAny) {
+		fmt.Printf("Permission mismatch in RestoreTrash, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to restore from the trash!")
+		return
+	}
+
+	var data TrashData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var restored int64
+	for _, table := range []string{"posts", "comments"} {
+		res, err := db.Exec(`
+			UPDATE `+table+` SET deleted_at = NULL, deleted_by = NULL
+			WHERE id = ? AND deleted_at IS NOT NULL
+		`, data.Id)
+		if err != nil {
+			log.Printf("Error restoring ID %s from the trash: %v\n", data.Id, err)
+			WriteJSONError(w, http.StatusInternalServerError, "Server error")
+			return
+		}
+
+		if restored, _ = res.RowsAffected(); restored > 0 {
+			break
+		}
+	}
+
+	if restored == 0 {
+		WriteJSONError(w, http.StatusNotFound, "Nothing with that ID is in the trash")
+		return
+	}
+
+	fmt.Printf("ID %s restored from the trash\n", data.Id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+	})
+}
+
+// purges a single post or comment from the trash right away instead of waiting for the retention
+func PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermDeleteAny) {
+		fmt.Printf("Permission mismatch in PurgeTrash, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to purge the trash!")
+		return
+	}
+
+	var data TrashData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction in PurgeTrash: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer tx.Rollback()
+
+	var isPost, isComment bool
+	err = tx.QueryRow(`
+		SELECT
+			EXISTS(SELECT 1 FROM posts WHERE id = ? AND deleted_at IS NOT NULL),
+			EXISTS(SELECT 1 FROM comments WHERE id = ? AND deleted_at IS NOT NULL)
+	`, data.Id, data.Id).Scan(&isPost, &isComment)
+	if err != nil {
+		log.Printf("Error looking up ID %s in the trash: %v\n", data.Id, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	var commentsRemoved int64
+	var imagePaths []string
+	switch {
+	case isPost:
+		commentsRemoved, imagePaths, err = deletePostRows(tx, data.Id)
+	case isComment:
+		commentsRemoved = 1
+		imagePaths, err = deleteCommentRows(tx, data.Id)
+	default:
+		WriteJSONError(w, http.StatusNotFound, "Nothing with that ID is in the trash")
+		return
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error purging ID %s from the trash: %v\n", data.Id, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	filesRemoved := ReleaseUploads(imagePaths...)
+	fmt.Printf("ID %s purged from the trash (%d comments, %d files)\n", data.Id, commentsRemoved, filesRemoved)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":          "success",
+		"commentsremoved": strconv.FormatInt(commentsRemoved, 10),
+		"filesremoved":    strconv.Itoa(filesRemoved),
+	})
+}
+
+func queryTrashIds(tx *sql.Tx, table string, cutoff string) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT id FROM `+table+` WHERE deleted_at IS NOT NULL AND deleted_at <= ?
+	`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+/*
+purges every post and comment that's been in the trash for longer than retention, returning how many
+posts and comments were purged and how many files were removed along with them
+*/
+func PurgeExpiredTrash(retention time.Duration) (int, int64, int, error) {
+	// deleted_at is written by CURRENT_TIMESTAMP, which is UTC in this format
+	cutoff := time.Now().Add(-retention).UTC().Format("2006-01-02 15:04:05")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	postIds, err := queryTrashIds(tx, "posts", cutoff)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var commentsPurged int64
+	var imagePaths []string
+	for _, id := range postIds {
+		comments, paths, err := deletePostRows(tx, id)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		commentsPurged += comments
+		imagePaths = append(imagePaths, paths...)
+	}
+
+	// comments that were deleted on their own, the ones under purged posts are already gone by now
+	commentIds, err := queryTrashIds(tx, "comments", cutoff)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	for _, id := range commentIds {
+		paths, err := deleteCommentRows(tx, id)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+
+		commentsPurged++
+		imagePaths = append(imagePaths, paths...)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return len(postIds), commentsPurged, ReleaseUploads(imagePaths...), nil
+}
+
+// runs PurgeExpiredTrash() in the background every interval for as long as the server is up
+func StartTrashPurger(interval time.Duration) {
+	if Cfg.TrashRetention == 0 {
+		fmt.Println("TRASH_RETENTION is 0, deleted posts and comments are kept in the trash forever")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			posts, comments, files, err := PurgeExpiredTrash(Cfg.TrashRetention)
+			if err != nil {
+				log.Printf("Error purging the trash: %v\n", err)
+				continue
+			}
+
+			if posts > 0 || comments > 0 {
+				fmt.Printf("Purged %d post(s), %d comment(s) and %d file(s) from the trash\n", posts, comments, files)
+			}
+		}
+	}()
+}
@@ -0,0 +1,773 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	saving uploads, and keeping the stored files and the imagepath / thumbpath columns in line with each
+	other. the files themselves live wherever Uploads keeps them, see storageController.go.
+
+	SaveUpload() is what AddPost() and AddComment() both use to store the image or video clip of a form
+	(together with its thumbnail, see thumbnailController.go). what can be uploaded, and how big, is up to
+	Cfg.UploadTypes, Cfg.UploadMaxSize, Cfg.UploadMaxWidth / Cfg.UploadMaxHeight and Cfg.UploadMaxDuration.
+
+	files are stored under the SHA-256 of their contents instead of their original name, so the same
+	image posted ten times is only stored once (and names with personal info in them don't end up in
+	URLs). the "uploads" table counts how many posts and comments point at each file: SaveUpload() adds
+	a reference, ReleaseUploads() takes one away once a row is deleted and only removes the file when
+	the last one is gone. files from before this have no row there, those belong to one post or comment
+	only and are removed right away. uploadsMu keeps a file from being removed while it's being reused.
+
+	AddPost() and AddComment() write the file before inserting the row, and deletions remove the rows
+	before the files, so a crash or a failed step in between leaves the two drifting apart:
+	  - orphaned files: stored files that no post or comment points at anymore
+	  - missing files: posts or comments whose imagepath or thumbpath points at a file that isn't there
+
+	CheckUploads() finds both (and reference counts that are off) and, when asked to, fixes them by
+	removing the orphaned files, clearing the paths of rows with missing files and correcting the counts.
+	files written in the last uploadGracePeriod are left alone, since those might belong to an upload
+	whose row is still on its way into the database.
+
+	it runs in the background (see StartUploadChecker(), Cfg.UploadCheck) and from the command line
+	through -check-uploads and -fix-uploads
+*/
+
+const (
+	UploadsDir = "uploads"
+
+	uploadGracePeriod = time.Hour
+
+	// room for the rest of a form on top of the file in it, the post content and such
+	uploadFormOverhead = 1 << 20
+
+	UploadCheckOff    = "off"
+	UploadCheckReport = "report"
+	UploadCheckFix    = "fix"
+)
+
+var (
+	ErrUnsupportedUpload = errors.New("unsupported file format")
+	ErrUploadTooLarge    = errors.New("upload is too large")
+)
+
+var uploadsMu sync.Mutex
+
+/*
+struct for a post or comment with an upload
+  - Id: ID of the post or comment
+  - Kind: either "post" or "comment"
+  - Imagepath: the imagepath as it's stored in the row
+  - Thumbpath: the thumbpath as it's stored in the row, empty without a thumbnail
+*/
+type UploadReference struct {
+	Id        string
+	Kind      string
+	Imagepath string
+	Thumbpath string
+}
+
+/*
+struct for a post or comment pointing at an upload that doesn't exist
+  - Id: ID of the post or comment
+  - Kind: either "post" or "comment"
+  - Column: which of the paths is missing, "imagepath" or "thumbpath"
+  - Path: the path as it's stored in the row
+*/
+type MissingUpload struct {
+	Id     string
+	Kind   string
+	Column string
+	Path   string
+}
+
+/*
+struct for a file whose reference count in "uploads" doesn't match how many rows point at it
+  - Path: path of the file
+  - Counted: the reference count in "uploads"
+  - Actual: how many posts and comments actually point at it
+*/
+type MiscountedUpload struct {
+	Path    string
+	Counted int
+	Actual  int
+}
+
+/*
+struct for the outcome of CheckUploads()
+  - Orphaned: paths of files in uploads/ nothing points at
+  - Missing: rows pointing at files that don't exist
+  - Miscounted: files with a wrong reference count
+  - Fixed: whether the problems above have been fixed, or only reported
+  - FilesRemoved: how many of the orphaned files could be removed when fixing
+  - RowsCleared: how many paths in rows were cleared when fixing
+*/
+type UploadReport struct {
+	Orphaned     []string
+	Missing      []MissingUpload
+	Miscounted   []MiscountedUpload
+	Fixed        bool
+	FilesRemoved int
+	RowsCleared  int64
+}
+
+/*
+turns an imagepath as stored in posts / comments into a path on this machine. older rows were written
+on windows and use backslashes as separators, so those get normalized first
+*/
+func NormalizeUploadPath(imagePath string) string {
+	return filepath.Clean(filepath.FromSlash(strings.ReplaceAll(imagePath, `\`, "/")))
+}
+
+// the key a file is counted under in "uploads", the same no matter how its path was written down
+func uploadKey(imagePath string) string {
+	return uploadPath(uploadName(imagePath))
+}
+
+// adds a reference to every non-empty path, has to be called with uploadsMu held
+func acquireUploads(paths ...string) error {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+
+		_, err := db.Exec(`
+			INSERT INTO uploads (path, refcount) VALUES (?, 1)
+			ON CONFLICT (path) DO UPDATE SET refcount = refcount + 1
+		`, uploadKey(path))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+takes away a reference from path, returning whether that was the last one and the file has to go.
+files without a row in "uploads" are from before it existed and only ever had the one reference
+*/
+func releaseUpload(path string) (bool, error) {
+	var refcount int
+	err := db.QueryRow(`
+		UPDATE uploads SET refcount = refcount - 1 WHERE path = ? RETURNING refcount
+	`, uploadKey(path)).Scan(&refcount)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if refcount > 0 {
+		return false, nil
+	}
+
+	_, err = db.Exec(`DELETE FROM uploads WHERE path = ?`, uploadKey(path))
+	return err == nil, err
+}
+
+/*
+takes away a reference from every upload in imagePaths, once the rows pointing at them are deleted, and
+removes the files nothing points at anymore. returns how many files were removed
+*/
+func ReleaseUploads(imagePaths ...string) int {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+
+	var unused []string
+	for _, imagePath := range imagePaths {
+		if imagePath == "" {
+			continue
+		}
+
+		last, err := releaseUpload(imagePath)
+		if err != nil {
+			log.Printf("Error releasing upload %s: %v\n", imagePath, err)
+			continue
+		}
+
+		if last {
+			unused = append(unused, imagePath)
+		}
+	}
+
+	return RemoveUploads(unused)
+}
+
+/*
+struct for a kind of file that can be uploaded
+  - MIME: what http.DetectContentType() makes of files of this kind
+  - Exts: file extensions these can be uploaded with
+  - Ext: file extension these are stored with
+  - Decodable: whether the standard library can decode it, which is what re-encoding and thumbnails need
+  - Video: whether it's a video clip instead of an image
+*/
+type UploadType struct {
+	MIME      string
+	Exts      []string
+	Ext       string
+	Decodable bool
+	Video     bool
+}
+
+// every kind of file that can be uploaded, by the name Cfg.UploadTypes (and image.Decode()) knows it as
+var uploadTypes = map[string]UploadType{
+	"jpeg": {MIME: "image/jpeg", Exts: []string{".jpg", ".jpeg"}, Ext: ".jpg", Decodable: true},
+	"png":  {MIME: "image/png", Exts: []string{".png"}, Ext: ".png", Decodable: true},
+	"gif":  {MIME: "image/gif", Exts: []string{".gif"}, Ext: ".gif", Decodable: true},
+	"webp": {MIME: "image/webp", Exts: []string{".webp"}, Ext: ".webp"},
+	"webm": {MIME: "video/webm", Exts: []string{".webm"}, Ext: ".webm", Video: true},
+	"mp4":  {MIME: "video/mp4", Exts: []string{".mp4", ".m4v"}, Ext: ".mp4", Video: true},
+}
+
+// the file extensions of every kind of upload allowed by Cfg.UploadTypes
+func allowedUploadExts() []string {
+	var exts []string
+	for _, name := range Cfg.UploadTypes {
+		exts = append(exts, uploadTypes[name].Exts...)
+	}
+	return exts
+}
+
+/*
+sniffs the first 512 bytes of file for what kind of upload it is, returning its name in uploadTypes.
+returns false if it isn't one Cfg.UploadTypes allows
+*/
+func detectUploadType(file io.ReadSeeker) (string, bool) {
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+	file.Seek(0, io.SeekStart)
+
+	mime := http.DetectContentType(buf[:n])
+	for _, name := range Cfg.UploadTypes {
+		if uploadTypes[name].MIME == mime {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// the kind of upload (as in uploadTypes) stored at imagePath, going by its extension
+func storedUploadType(imagePath string) UploadType {
+	ext := strings.ToLower(filepath.Ext(imagePath))
+	for _, uploadType := range uploadTypes {
+		if slices.Contains(uploadType.Exts, ext) {
+			return uploadType
+		}
+	}
+	return UploadType{}
+}
+
+/*
+parses the multipart form of a request that can carry an upload, refusing bodies bigger than
+Cfg.UploadMaxSize (plus some room for the rest of the form). writes the error response and returns
+false if it couldn't be parsed
+*/
+func ParseUploadForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, Cfg.UploadMaxSize+uploadFormOverhead)
+
+	err := r.ParseMultipartForm(10 << 20)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		WriteUploadError(w, ErrUploadTooLarge)
+		return false
+	}
+
+	log.Printf("Error parsing upload form: %v\n", err)
+	WriteJSONError(w, http.StatusBadRequest, "Invalid form")
+	return false
+}
+
+/*
+saves the "image" file of a multipart form to the storage and creates its thumbnail, returning the paths
+of both. images are re-encoded (see imageController.go), WebPs and videos only cleaned up (CleanWebP(),
+videoController.go). the paths are empty if the form has no file, and the thumbnail path is also empty
+if the upload didn't need one, can't have one (WebP, video) or creating it failed, which isn't worth
+failing the whole upload over
+*/
+func SaveUpload(r *http.Request) (string, string, error) {
+	file, handler, err := r.FormFile("image")
+	if err == http.ErrMissingFile {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	if handler.Size > Cfg.UploadMaxSize {
+		return "", "", ErrUploadTooLarge
+	}
+
+	typeName, ok := detectUploadType(file)
+	if !ok || !IsAcceptedFileFormat(handler.Filename, allowedUploadExts()) {
+		return "", "", ErrUnsupportedUpload
+	}
+
+	var data []byte
+	var img image.Image
+
+	switch {
+	case uploadTypes[typeName].Decodable:
+		// the extension follows what the file turned out to be, not what it was called
+		data, img, typeName, err = ReencodeImage(file)
+	case typeName == "webp":
+		data, err = readUploadFile(file)
+		if err == nil {
+			data, err = CleanWebP(data)
+		}
+	default:
+		data, err = readUploadFile(file)
+		if err == nil {
+			data, err = CleanVideo(data, typeName)
+		}
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256(data)
+	imagePath := uploadPath(hex.EncodeToString(sum[:]) + uploadTypes[typeName].Ext)
+
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+
+	/*
+		a file that's already there gets written again all the same (with the same contents), so the
+		upload check doesn't take it for an orphan before the new reference is in. same for the thumbnail
+	*/
+	if err := Uploads.Put(uploadName(imagePath), data); err != nil {
+		return "", "", err
+	}
+
+	thumbPath := ""
+	if img != nil {
+		thumbPath, err = CreateThumbnail(img, imagePath)
+		if err != nil {
+			log.Printf("Error creating thumbnail of %s: %v\n", imagePath, err)
+		}
+	}
+
+	if err := acquireUploads(imagePath, thumbPath); err != nil {
+		return "", "", err
+	}
+
+	return imagePath, thumbPath, nil
+}
+
+// reads all of an uploaded file, which can't be bigger than Cfg.UploadMaxSize by now
+func readUploadFile(file io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(file, Cfg.UploadMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > Cfg.UploadMaxSize {
+		return nil, ErrUploadTooLarge
+	}
+	return data, nil
+}
+
+/*
+writes the error response for an error SaveUpload() (or ParseUploadForm()) returned: 415 for anything
+that isn't a file we take, 413 for files over the limits and 500 for everything that went wrong on our end
+*/
+func WriteUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnsupportedUpload):
+		WriteJSONError(w, http.StatusUnsupportedMediaType, "Unsupported file format!")
+	case errors.Is(err, ErrUndecodableUpload):
+		WriteJSONError(w, http.StatusUnsupportedMediaType, "File could not be read, it might be damaged")
+	case errors.Is(err, ErrUnknownVideoDuration):
+		WriteJSONError(w, http.StatusUnsupportedMediaType, "Video length could not be determined, try saving it again with a video editor")
+	case errors.Is(err, ErrUploadTooLarge):
+		WriteJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("File is too large! At most %s", FormatByteSize(Cfg.UploadMaxSize)))
+	case errors.Is(err, ErrDimensionsTooLarge):
+		WriteJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Dimensions are too large! At most %dx%d", Cfg.UploadMaxWidth, Cfg.UploadMaxHeight))
+	case errors.Is(err, ErrAnimationTooLarge):
+		WriteJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Animation is too large! All frames together can have at most %dx%d pixels", Cfg.UploadMaxWidth, Cfg.UploadMaxHeight))
+	case errors.Is(err, ErrVideoTooLong):
+		WriteJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Video is too long! At most %s", Cfg.UploadMaxDuration))
+	default:
+		log.Printf("Error saving upload: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Encountered error with file on back-end.")
+	}
+}
+
+func queryUploadReferences() ([]UploadReference, error) {
+	rows, err := db.Query(`
+		SELECT id, ?, imagepath, thumbpath FROM posts WHERE imagepath != ''
+		UNION ALL
+		SELECT id, ?, imagepath, thumbpath FROM comments WHERE imagepath != ''
+	`, RevisionKindPost, RevisionKindComment)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var references []UploadReference
+	for rows.Next() {
+		var reference UploadReference
+		if err := rows.Scan(&reference.Id, &reference.Kind, &reference.Imagepath, &reference.Thumbpath); err != nil {
+			return nil, err
+		}
+		references = append(references, reference)
+	}
+
+	return references, rows.Err()
+}
+
+/*
+runs query inside tx, which has to select (imagepath, thumbpath) pairs, and returns every non-empty path
+in it. used to collect the files that have to go once rows are deleted
+*/
+func queryUploadPaths(tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var imagePath, thumbPath string
+		if err := rows.Scan(&imagePath, &thumbPath); err != nil {
+			return nil, err
+		}
+
+		for _, path := range []string{imagePath, thumbPath} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	return paths, rows.Err()
+}
+
+// returns the reference count of every file in "uploads"
+func queryUploadRefcounts() (map[string]int, error) {
+	rows, err := db.Query(`SELECT path, refcount FROM uploads`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refcounts := make(map[string]int)
+	for rows.Next() {
+		var path string
+		var refcount int
+		if err := rows.Scan(&path, &refcount); err != nil {
+			return nil, err
+		}
+		refcounts[path] = refcount
+	}
+
+	return refcounts, rows.Err()
+}
+
+// whether the file at path was written recently enough that it might still be in the middle of an upload
+func withinUploadGracePeriod(path string) bool {
+	info, err := Uploads.Stat(uploadName(path))
+	return err == nil && info.ModTime.After(time.Now().Add(-uploadGracePeriod))
+}
+
+/*
+the references to every upload and their reference counts, read together under uploadsMu so neither
+is half-way through an upload or a release
+*/
+type uploadSnapshot struct {
+	references []UploadReference
+	refcounts  map[string]int
+}
+
+// has to be called with uploadsMu held
+func queryUploadSnapshot() (uploadSnapshot, error) {
+	references, err := queryUploadReferences()
+	if err != nil {
+		return uploadSnapshot{}, err
+	}
+
+	refcounts, err := queryUploadRefcounts()
+	if err != nil {
+		return uploadSnapshot{}, err
+	}
+
+	return uploadSnapshot{references, refcounts}, nil
+}
+
+// how many paths in rows point at each file, whether it exists or not
+func (snapshot uploadSnapshot) pointers() map[string]int {
+	pointers := make(map[string]int)
+	for _, reference := range snapshot.references {
+		for _, storedPath := range []string{reference.Imagepath, reference.Thumbpath} {
+			if storedPath != "" {
+				pointers[uploadKey(storedPath)]++
+			}
+		}
+	}
+	return pointers
+}
+
+// compares snapshot against the stored files, this goes to the storage for every file so it runs without uploadsMu
+func findUploadProblems(snapshot uploadSnapshot) (UploadReport, error) {
+	var report UploadReport
+
+	// a file is often pointed at more than once (thumbnails especially), it only has to be looked up once
+	exists := make(map[string]bool)
+	stat := func(storedPath string) (bool, error) {
+		name := uploadName(storedPath)
+		if found, ok := exists[name]; ok {
+			return found, nil
+		}
+
+		_, err := Uploads.Stat(name)
+		if err != nil && !errors.Is(err, ErrUploadNotFound) {
+			return false, err
+		}
+		exists[name] = err == nil
+		return err == nil, nil
+	}
+
+	refcounts := maps.Clone(snapshot.refcounts)
+	actualCounts := make(map[string]int)
+	referenced := make(map[string]bool, len(snapshot.references))
+	for _, reference := range snapshot.references {
+		for _, column := range []string{"imagepath", "thumbpath"} {
+			storedPath := reference.Imagepath
+			if column == "thumbpath" {
+				storedPath = reference.Thumbpath
+			}
+			if storedPath == "" {
+				continue
+			}
+
+			path := uploadKey(storedPath)
+			referenced[path] = true
+
+			// rows pointing at a missing file get cleared when fixing, so those don't count
+			found, err := stat(storedPath)
+			if err != nil {
+				return report, err
+			}
+			if !found {
+				report.Missing = append(report.Missing, MissingUpload{
+					Id:     reference.Id,
+					Kind:   reference.Kind,
+					Column: column,
+					Path:   storedPath,
+				})
+				continue
+			}
+
+			actualCounts[path]++
+		}
+	}
+
+	// files without a row are fine as long as only one row points at them, see releaseUpload()
+	for path, actual := range actualCounts {
+		if _, counted := refcounts[path]; !counted && actual > 1 {
+			refcounts[path] = 0
+		}
+	}
+
+	for path, counted := range refcounts {
+		actual := actualCounts[path]
+		if counted == actual || withinUploadGracePeriod(path) {
+			continue
+		}
+
+		report.Miscounted = append(report.Miscounted, MiscountedUpload{
+			Path:    path,
+			Counted: counted,
+			Actual:  actual,
+		})
+	}
+	sort.Slice(report.Miscounted, func(i, j int) bool {
+		return report.Miscounted[i].Path < report.Miscounted[j].Path
+	})
+
+	files, err := Uploads.List()
+	if err != nil {
+		return report, err
+	}
+
+	cutoff := time.Now().Add(-uploadGracePeriod)
+	for _, file := range files {
+		path := uploadPath(file.Name)
+		if referenced[path] || file.ModTime.After(cutoff) {
+			continue
+		}
+
+		report.Orphaned = append(report.Orphaned, path)
+	}
+
+	return report, nil
+}
+
+/*
+drops every problem in report about a file that was uploaded or released between before and after, it
+might not be one anymore. the next check will find it again if it still is
+*/
+func (report *UploadReport) keepUnchanged(before, after uploadSnapshot) {
+	beforePointers, afterPointers := before.pointers(), after.pointers()
+	unchanged := func(path string) bool {
+		path = uploadKey(path)
+		beforeCount, beforeCounted := before.refcounts[path]
+		afterCount, afterCounted := after.refcounts[path]
+		return beforeCount == afterCount && beforeCounted == afterCounted && beforePointers[path] == afterPointers[path]
+	}
+
+	report.Orphaned = slices.DeleteFunc(report.Orphaned, func(path string) bool {
+		return !unchanged(path)
+	})
+	report.Missing = slices.DeleteFunc(report.Missing, func(missing MissingUpload) bool {
+		return !unchanged(missing.Path)
+	})
+	report.Miscounted = slices.DeleteFunc(report.Miscounted, func(miscounted MiscountedUpload) bool {
+		return !unchanged(miscounted.Path)
+	})
+}
+
+/*
+compares uploads/ and the reference counts in "uploads" against every imagepath and thumbpath in posts
+and comments (the ones in the trash included, they can still be restored). with fix set, orphaned files
+are removed, rows with missing files get those paths cleared and wrong counts are corrected, otherwise
+nothing is touched and the report is all there is.
+
+the storage is only gone through without uploadsMu held, on S3 every file it looks at is a request and
+uploads would be stuck for the whole pass otherwise. whatever it finds is checked against the database
+again under uploadsMu before it's reported or fixed
+*/
+func CheckUploads(fix bool) (UploadReport, error) {
+	uploadsMu.Lock()
+	before, err := queryUploadSnapshot()
+	uploadsMu.Unlock()
+	if err != nil {
+		return UploadReport{Fixed: fix}, err
+	}
+
+	report, err := findUploadProblems(before)
+	report.Fixed = fix
+	if err != nil || !report.HasProblems() {
+		return report, err
+	}
+
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+
+	after, err := queryUploadSnapshot()
+	if err != nil {
+		return report, err
+	}
+	report.keepUnchanged(before, after)
+
+	if !fix {
+		return report, nil
+	}
+
+	report.FilesRemoved = RemoveUploads(report.Orphaned)
+
+	for _, miscounted := range report.Miscounted {
+		if miscounted.Actual == 0 {
+			_, err = db.Exec(`DELETE FROM uploads WHERE path = ?`, miscounted.Path)
+		} else {
+			_, err = db.Exec(`
+				INSERT INTO uploads (path, refcount) VALUES (?, ?)
+				ON CONFLICT (path) DO UPDATE SET refcount = excluded.refcount
+			`, miscounted.Path, miscounted.Actual)
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+
+	for _, missing := range report.Missing {
+		table := "posts"
+		if missing.Kind == RevisionKindComment {
+			table = "comments"
+		}
+
+		// only clear it if it still points at the same file, it might have been edited in the meantime
+		res, err := db.Exec(`
+			UPDATE `+table+` SET `+missing.Column+` = '' WHERE id = ? AND `+missing.Column+` = ?
+		`, missing.Id, missing.Path)
+		if err != nil {
+			return report, err
+		}
+
+		affected, _ := res.RowsAffected()
+		report.RowsCleared += affected
+	}
+
+	return report, nil
+}
+
+func (report UploadReport) HasProblems() bool {
+	return len(report.Orphaned) > 0 || len(report.Missing) > 0 || len(report.Miscounted) > 0
+}
+
+// prints everything in report, one line per file or row
+func PrintUploadReport(report UploadReport) {
+	for _, path := range report.Orphaned {
+		fmt.Printf("  orphaned file: %s\n", path)
+	}
+	for _, missing := range report.Missing {
+		fmt.Printf("  missing file: %s ID %s %s points at %s\n", missing.Kind, missing.Id, missing.Column, missing.Path)
+	}
+	for _, miscounted := range report.Miscounted {
+		fmt.Printf("  miscounted file: %s has %d reference(s), counted %d\n", miscounted.Path, miscounted.Actual, miscounted.Counted)
+	}
+
+	fmt.Printf("%d orphaned file(s), %d row(s) pointing at missing files, %d miscounted file(s)\n", len(report.Orphaned), len(report.Missing), len(report.Miscounted))
+	if report.Fixed {
+		fmt.Printf("Removed %d file(s), cleared %d path(s) in rows, corrected %d count(s)\n", report.FilesRemoved, report.RowsCleared, len(report.Miscounted))
+	} else if report.HasProblems() {
+		fmt.Println("Nothing was changed, run with -fix-uploads to fix these")
+	}
+}
+
+// runs CheckUploads() in the background every interval, fixing or only reporting depending on Cfg.UploadCheck
+func StartUploadChecker(interval time.Duration) {
+	if Cfg.UploadCheck == UploadCheckOff {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			report, err := CheckUploads(Cfg.UploadCheck == UploadCheckFix)
+			if err != nil {
+				log.Printf("Error checking uploads: %v\n", err)
+				continue
+			}
+
+			if report.HasProblems() {
+				fmt.Println("Upload check found uploads and posts out of line:")
+				PrintUploadReport(report)
+			}
+		}
+	}()
+}
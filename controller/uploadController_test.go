@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+/*
+a Storage that fails the test whenever it's gone through with uploadsMu held, and calls onList (if set)
+right after listing, to change things in the middle of CheckUploads()
+*/
+type unlockedStorage struct {
+	Storage
+	t      *testing.T
+	onList func()
+}
+
+func (s unlockedStorage) checkUnlocked(method string) {
+	if !uploadsMu.TryLock() {
+		s.t.Errorf("%s() called with uploadsMu held", method)
+		return
+	}
+	uploadsMu.Unlock()
+}
+
+func (s unlockedStorage) Stat(name string) (StoredFile, error) {
+	s.checkUnlocked("Stat")
+	return s.Storage.Stat(name)
+}
+
+func (s unlockedStorage) List() ([]StoredFile, error) {
+	s.checkUnlocked("List")
+	files, err := s.Storage.List()
+	if s.onList != nil {
+		s.onList()
+	}
+	return files, err
+}
+
+// stores a file written two hours ago, past the grace period of the upload check
+func putOldUpload(t *testing.T, storage LocalStorage, name string) {
+	t.Helper()
+
+	if err := storage.Put(name, []byte("data of "+name)); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(storage.Dir, name), old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+an orphaned file, a post pointing at a missing one and a file counted five times that only one post
+points at, set up in local storage behind an unlockedStorage
+*/
+func setupBrokenUploads(t *testing.T) (LocalStorage, *unlockedStorage) {
+	openTestDB(t)
+	local := Uploads.(LocalStorage)
+	putOldUpload(t, local, "orphan.png")
+	putOldUpload(t, local, "kept.png")
+
+	_, err := db.Exec(`
+		INSERT INTO posts (id, username, postcontent, imagepath) VALUES (1, 'alice', '', ?), (2, 'bob', '', ?)
+	`, uploadPath("kept.png"), uploadPath("gone.png"))
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO uploads (path, refcount) VALUES (?, 5)`, uploadPath("kept.png"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &unlockedStorage{Storage: local, t: t}
+	Uploads = storage
+	return local, storage
+}
+
+func TestCheckUploads(t *testing.T) {
+	local, _ := setupBrokenUploads(t)
+
+	report, err := CheckUploads(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Orphaned, []string{uploadPath("orphan.png")}) || report.FilesRemoved != 1 {
+		t.Errorf("orphaned %v, removed %d", report.Orphaned, report.FilesRemoved)
+	}
+	if len(report.Missing) != 1 || report.Missing[0].Id != "2" || report.RowsCleared != 1 {
+		t.Errorf("missing %+v, cleared %d", report.Missing, report.RowsCleared)
+	}
+	if len(report.Miscounted) != 1 || report.Miscounted[0] != (MiscountedUpload{uploadPath("kept.png"), 5, 1}) {
+		t.Errorf("miscounted %+v", report.Miscounted)
+	}
+
+	if _, err := local.Stat("orphan.png"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("orphaned file is still there: %v", err)
+	}
+	var imagePath string
+	db.QueryRow(`SELECT imagepath FROM posts WHERE id = 2`).Scan(&imagePath)
+	if imagePath != "" {
+		t.Errorf("post pointing at a missing file still has imagepath %q", imagePath)
+	}
+	var refcount int
+	db.QueryRow(`SELECT refcount FROM uploads WHERE path = ?`, uploadPath("kept.png")).Scan(&refcount)
+	if refcount != 1 {
+		t.Errorf("refcount of kept.png = %d, want 1", refcount)
+	}
+
+	// nothing left the second time
+	if report, err := CheckUploads(true); err != nil || report.HasProblems() {
+		t.Fatalf("CheckUploads() again = %+v, %v", report, err)
+	}
+}
+
+// files that are uploaded or released while the storage is gone through are left for the next check
+func TestCheckUploadsRechecks(t *testing.T) {
+	local, storage := setupBrokenUploads(t)
+
+	// what SaveUpload() does for the same images, once the check has already looked at the storage
+	storage.onList = func() {
+		// List() already failed the test if the lock is held, waiting for it here would only hang
+		if !uploadsMu.TryLock() {
+			return
+		}
+		defer uploadsMu.Unlock()
+
+		if err := local.Put("gone.png", []byte("data of gone.png")); err != nil {
+			t.Fatal(err)
+		}
+		_, err := db.Exec(`
+			INSERT INTO posts (id, username, postcontent, imagepath) VALUES (3, 'carol', '', ?), (4, 'dave', '', ?)
+		`, uploadPath("orphan.png"), uploadPath("gone.png"))
+		if err == nil {
+			err = acquireUploads(uploadPath("orphan.png"), uploadPath("gone.png"))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := CheckUploads(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphaned) != 0 || len(report.Missing) != 0 || len(report.Miscounted) != 1 {
+		t.Fatalf("CheckUploads() = %+v", report)
+	}
+
+	if _, err := local.Stat("orphan.png"); err != nil {
+		t.Errorf("file uploaded again during the check was removed: %v", err)
+	}
+	var imagePath string
+	db.QueryRow(`SELECT imagepath FROM posts WHERE id = 2`).Scan(&imagePath)
+	if imagePath != uploadPath("gone.png") {
+		t.Errorf("post whose file was uploaded during the check has imagepath %q", imagePath)
+	}
+}
@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+	user management for the dashboard: listing every account (paginated, searchable by username) with
+	its role, how much it posted and when it last logged in, and changing the role of an account without
+	having to delete it first.
+
+	"admin" here means any role that can manage users, and the board always has to keep at least one of
+	those around. demoting or deleting the last one would leave nobody able to get into the dashboard,
+	so SetUserRank() and DeleteUser() refuse to do it. the check happens inside the same statement as the
+	change itself so two admins demoting each other at the same time can't both get through
+*/
+
+const (
+	userListDefaultPageSize = 25
+	userListMaxPageSize     = 100
+)
+
+// roles that count as an admin for the last-admin protection
+const adminRolesSQL = `SELECT role FROM role_permissions WHERE permission = 'manage-users'`
+
+/*
+struct for user-related data that we can assemble and serve in the user listing
+  - Username: Username of the account
+  - Role: role of the account
+  - PostCount: how many posts the account has made
+  - CommentCount: how many comments the account has made
+  - LastLogin: timestamp of the last login, empty for never
+*/
+type UserListData struct {
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	PostCount    int    `json:"postcount"`
+	CommentCount int    `json:"commentcount"`
+	LastLogin    string `json:"lastlogin"`
+}
+
+/*
+struct for a single page of the user listing
+  - Users: the accounts on this page
+  - Total: how many accounts match the search over every page
+  - Page: the page number, starting at 1
+  - PageSize: how many accounts there are at most per page
+*/
+type UserListPage struct {
+	Users    []UserListData `json:"users"`
+	Total    int            `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"pagesize"`
+}
+
+func RecordLastLogin(username string) {
+	err := WriteToSQL(`
+		UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE username = ? COLLATE NOCASE
+	`, username)
+	if err != nil {
+		log.Printf("Error recording last login of %s: %v\n", username, err)
+	}
+}
+
+// escapes the wildcards of a LIKE pattern, to be used together with ESCAPE '\'
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `%`, `\%`)
+	return strings.ReplaceAll(s, `_`, `\_`)
+}
+
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch in ListUsers, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to list users!")
+		return
+	}
+
+	query := r.URL.Query()
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(query.Get("pagesize"))
+	if err != nil || pageSize < 1 {
+		pageSize = userListDefaultPageSize
+	}
+	pageSize = min(pageSize, userListMaxPageSize)
+
+	search := "%" + escapeLike(query.Get("search")) + "%"
+
+	result := UserListPage{
+		Users:    []UserListData{},
+		Page:     page,
+		PageSize: pageSize,
+	}
+
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM users WHERE username LIKE ? ESCAPE '\'
+	`, search).Scan(&result.Total)
+	if err != nil {
+		log.Printf("Error counting users: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			users.username,
+			users.role,
+			(SELECT COUNT(*) FROM posts WHERE posts.username = users.username),
+			(SELECT COUNT(*) FROM comments WHERE comments.username = users.username),
+			COALESCE(users.last_login, '')
+		FROM users
+		WHERE users.username LIKE ? ESCAPE '\'
+		ORDER BY users.username COLLATE NOCASE
+		LIMIT ? OFFSET ?
+	`, search, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Printf("Error querying users: %v\n", err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user UserListData
+		err := rows.Scan(&user.Username, &user.Role, &user.PostCount, &user.CommentCount, &user.LastLogin)
+		if err != nil {
+			log.Printf("Error scanning user: %v\n", err)
+			continue
+		}
+
+		result.Users = append(result.Users, user)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func SetUserRank(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermManageUsers) {
+		fmt.Printf("Permission mismatch in SetUserRank, invalid perms!\n")
+		WriteJSONError(w, http.StatusForbidden, "No permission to change roles!")
+		return
+	}
+
+	var data UserData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !RoleExists(data.Role) {
+		WriteJSONError(w, http.StatusBadRequest, "Role does not exist")
+		return
+	}
+
+	username, ok := canonicalUsernameOrError(w, data.Username)
+	if !ok {
+		return
+	}
+
+	res, err := db.Exec(`
+		UPDATE users SET role = ?
+		WHERE username = ?
+		AND NOT (
+			role IN (`+adminRolesSQL+`)
+			AND ? NOT IN (`+adminRolesSQL+`)
+			AND (SELECT COUNT(*) FROM users WHERE role IN (`+adminRolesSQL+`)) <= 1
+		)
+	`, data.Role, username, data.Role)
+	if err != nil {
+		log.Printf("Error setting role of %s: %v\n", username, err)
+		WriteJSONError(w, http.StatusInternalServerError, "Server error")
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		if GetUserRole(username) == "" {
+			WriteJSONError(w, http.StatusNotFound, "User does not exist")
+			return
+		}
+
+		WriteJSONError(w, http.StatusConflict, "Can't demote the last remaining admin")
+		return
+	}
+
+	currentUsername := GetUsernameFromCookie(r, "userSessionToken")
+	fmt.Printf("Role of %s set to %s by %s\n", username, data.Role, currentUsername)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+	})
+}
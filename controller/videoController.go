@@ -0,0 +1,567 @@
+package controller
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"time"
+)
+
+/*
+	short video clips, WebM and MP4.
+
+	there's nothing in the standard library to decode video with, and pulling in ffmpeg for a few clips
+	isn't worth it, so unlike images these aren't re-encoded. what gets checked instead is the container
+	around the video: that it's actually a WebM / MP4 all the way through (a file only starting like one
+	gets rejected), how long it runs (Cfg.UploadMaxDuration) and how big the picture is.
+
+	the metadata is blanked out on the way, phones put the GPS coordinates into videos just like into
+	photos. it can't just be cut out since other parts of the file point at byte offsets after it, so
+	it gets overwritten in place with padding of the same size instead: "free" boxes in MP4, Void
+	elements in WebM. anything trailing after the container is cut off.
+
+	the length has to be written down in the file. recordings straight out of a browser (MediaRecorder)
+	don't have it and are rejected, those have to go through a video editor first
+*/
+
+var (
+	ErrVideoTooLong         = errors.New("video is too long")
+	ErrUnknownVideoDuration = errors.New("video length could not be determined")
+
+	errUnknownSizeEBMLElement = errors.New("element of unknown size")
+)
+
+// boxes and elements that only hold metadata (user data, tags, attached files), those get blanked out
+var (
+	mp4MetadataBoxes     = map[string]bool{"udta": true, "meta": true, "uuid": true}
+	webmMetadataElements = map[uint32]bool{ebmlTags: true, ebmlAttachments: true}
+)
+
+/*
+struct for what CleanVideo() found out about a clip
+  - Duration: how long it runs
+  - Width, Height: size of the picture in pixels, of the biggest video track if there are several
+*/
+type VideoInfo struct {
+	Duration time.Duration
+	Width    int
+	Height   int
+}
+
+/*
+checks the WebM or MP4 (typeName, see uploadTypes) in data and blanks out its metadata, returning the
+cleaned up file. data itself is changed along the way
+*/
+func CleanVideo(data []byte, typeName string) ([]byte, error) {
+	var cleaned []byte
+	var info VideoInfo
+	var err error
+
+	switch typeName {
+	case "webm":
+		cleaned, info, err = cleanWebM(data)
+	case "mp4":
+		cleaned, info, err = cleanMP4(data)
+	default:
+		return nil, ErrUnsupportedUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Width <= 0 || info.Height <= 0 {
+		return nil, ErrUndecodableUpload
+	}
+	if !withinUploadDimensions(info.Width, info.Height) {
+		return nil, ErrDimensionsTooLarge
+	}
+	if info.Duration <= 0 {
+		return nil, ErrUnknownVideoDuration
+	}
+	if info.Duration > Cfg.UploadMaxDuration {
+		return nil, ErrVideoTooLong
+	}
+
+	return cleaned, nil
+}
+
+/*
+struct for an MP4 box (atom)
+  - Type: its four character type, like "moov"
+  - Start: offset of its header in the file
+  - DataStart: offset of its contents, right after the header
+  - End: offset right after it
+*/
+type mp4Box struct {
+	Type      string
+	Start     int
+	DataStart int
+	End       int
+}
+
+// reads the boxes that make up data[start:end], which have to fill it exactly
+func readMP4Boxes(data []byte, start, end int) ([]mp4Box, bool) {
+	var boxes []mp4Box
+
+	for i := start; i < end; {
+		if i+8 > end {
+			return nil, false
+		}
+
+		box := mp4Box{Type: string(data[i+4 : i+8]), Start: i, DataStart: i + 8}
+		size := uint64(binary.BigEndian.Uint32(data[i:]))
+		switch size {
+		case 0:
+			// runs until the end of whatever it's in
+			size = uint64(end - i)
+		case 1:
+			// the real size follows as 64 bits
+			if i+16 > end {
+				return nil, false
+			}
+			size = binary.BigEndian.Uint64(data[i+8:])
+			box.DataStart = i + 16
+		}
+
+		if size < uint64(box.DataStart-i) || size > uint64(end-i) {
+			return nil, false
+		}
+		box.End = i + int(size)
+
+		boxes = append(boxes, box)
+		i = box.End
+	}
+
+	return boxes, true
+}
+
+// overwrites box with a "free" box of the same size, which players skip
+func blankMP4Box(data []byte, box mp4Box) {
+	copy(data[box.Start+4:], "free")
+	clear(data[box.DataStart:box.End])
+}
+
+/*
+checks the MP4 in data: it has to start with "ftyp" and consist of nothing but boxes, with a movie
+header ("moov") telling how long it is and the media data ("mdat") itself. see ISO/IEC 14496-12
+*/
+func cleanMP4(data []byte) ([]byte, VideoInfo, error) {
+	var info VideoInfo
+
+	boxes, ok := readMP4Boxes(data, 0, len(data))
+	if !ok || len(boxes) == 0 || boxes[0].Type != "ftyp" {
+		return nil, info, ErrUndecodableUpload
+	}
+
+	var moov *mp4Box
+	hasMdat := false
+	for i, box := range boxes {
+		switch {
+		case box.Type == "moov":
+			if moov != nil {
+				return nil, info, ErrUndecodableUpload
+			}
+			moov = &boxes[i]
+		case box.Type == "mdat":
+			hasMdat = true
+		case mp4MetadataBoxes[box.Type]:
+			blankMP4Box(data, box)
+		}
+	}
+	if moov == nil || !hasMdat {
+		return nil, info, ErrUndecodableUpload
+	}
+
+	movieBoxes, ok := readMP4Boxes(data, moov.DataStart, moov.End)
+	if !ok {
+		return nil, info, ErrUndecodableUpload
+	}
+
+	for _, box := range movieBoxes {
+		switch {
+		case box.Type == "mvhd":
+			duration, ok := mp4MovieDuration(data[box.DataStart:box.End])
+			if !ok {
+				return nil, info, ErrUndecodableUpload
+			}
+			info.Duration = duration
+
+		case box.Type == "trak":
+			trackBoxes, ok := readMP4Boxes(data, box.DataStart, box.End)
+			if !ok {
+				return nil, info, ErrUndecodableUpload
+			}
+
+			for _, trackBox := range trackBoxes {
+				switch {
+				case trackBox.Type == "tkhd":
+					width, height, ok := mp4TrackDimensions(data[trackBox.DataStart:trackBox.End])
+					if !ok {
+						return nil, info, ErrUndecodableUpload
+					}
+					info.Width, info.Height = max(info.Width, width), max(info.Height, height)
+				case mp4MetadataBoxes[trackBox.Type]:
+					blankMP4Box(data, trackBox)
+				}
+			}
+
+		case mp4MetadataBoxes[box.Type]:
+			blankMP4Box(data, box)
+		}
+	}
+
+	return data, info, nil
+}
+
+// reads the duration out of the contents of a movie header box ("mvhd")
+func mp4MovieDuration(mvhd []byte) (time.Duration, bool) {
+	if len(mvhd) < 1 {
+		return 0, false
+	}
+
+	var timescale, duration uint64
+	switch mvhd[0] {
+	case 0:
+		// version, flags, creation and modification time, then 32 bits each of timescale and duration
+		if len(mvhd) < 20 {
+			return 0, false
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+		if duration == math.MaxUint32 {
+			return 0, true
+		}
+	case 1:
+		// same with 64 bit times and duration
+		if len(mvhd) < 32 {
+			return 0, false
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:]))
+		duration = binary.BigEndian.Uint64(mvhd[24:])
+		if duration == math.MaxUint64 {
+			return 0, true
+		}
+	default:
+		return 0, false
+	}
+
+	if timescale == 0 {
+		return 0, false
+	}
+
+	seconds := float64(duration) / float64(timescale)
+	if seconds > math.MaxInt64/float64(time.Second) {
+		return math.MaxInt64, true
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// reads the width and height out of the contents of a track header box ("tkhd"), both are 0 for audio
+func mp4TrackDimensions(tkhd []byte) (int, int, bool) {
+	if len(tkhd) < 1 {
+		return 0, 0, false
+	}
+
+	// the times and duration before them are 32 or 64 bits depending on the version
+	offset := 76
+	if tkhd[0] == 1 {
+		offset = 88
+	}
+	if len(tkhd) < offset+8 {
+		return 0, 0, false
+	}
+
+	// both are 16.16 fixed point numbers
+	width := int(binary.BigEndian.Uint32(tkhd[offset:]) >> 16)
+	height := int(binary.BigEndian.Uint32(tkhd[offset+4:]) >> 16)
+	return width, height, true
+}
+
+// IDs of the EBML (Matroska / WebM) elements that matter here, see https://www.matroska.org/technical/elements.html
+const (
+	ebmlHeader        = 0x1A45DFA3
+	ebmlDocType       = 0x4282
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlVideo         = 0xE0
+	ebmlPixelWidth    = 0xB0
+	ebmlPixelHeight   = 0xBA
+	ebmlTags          = 0x1254C367
+	ebmlAttachments   = 0x1941A469
+	ebmlVoid          = 0xEC
+)
+
+/*
+struct for an EBML element
+  - ID: its ID, with the length marker left in like it's written in the specification
+  - Start: offset of its header in the file
+  - DataStart: offset of its contents, right after the header
+  - End: offset right after it
+*/
+type ebmlElement struct {
+	ID        uint32
+	Start     int
+	DataStart int
+	End       int
+}
+
+/*
+reads the element at data[i:], which has to end before end. an element of unknown size (allowed for
+streaming) runs until end, but only if allowUnknownSize is set
+*/
+func readEBMLElement(data []byte, i, end int, allowUnknownSize bool) (ebmlElement, error) {
+	element := ebmlElement{Start: i}
+
+	if i >= end {
+		return element, ErrUndecodableUpload
+	}
+	idLength := bits.LeadingZeros8(data[i]) + 1
+	if idLength > 4 || i+idLength > end {
+		return element, ErrUndecodableUpload
+	}
+	for k := 0; k < idLength; k++ {
+		element.ID = element.ID<<8 | uint32(data[i+k])
+	}
+	i += idLength
+
+	if i >= end {
+		return element, ErrUndecodableUpload
+	}
+	sizeLength := bits.LeadingZeros8(data[i]) + 1
+	if sizeLength > 8 || i+sizeLength > end {
+		return element, ErrUndecodableUpload
+	}
+
+	// the length marker gets masked out, a size of all ones means it's unknown
+	size := uint64(data[i] & (0xff >> sizeLength))
+	unknown := size == uint64(0xff>>sizeLength)
+	for k := 1; k < sizeLength; k++ {
+		size = size<<8 | uint64(data[i+k])
+		unknown = unknown && data[i+k] == 0xff
+	}
+	element.DataStart = i + sizeLength
+
+	if unknown {
+		if !allowUnknownSize {
+			return element, errUnknownSizeEBMLElement
+		}
+		element.End = end
+		return element, nil
+	}
+
+	if size > uint64(end-element.DataStart) {
+		return element, ErrUndecodableUpload
+	}
+	element.End = element.DataStart + int(size)
+	return element, nil
+}
+
+// reads the elements that make up data[start:end], which have to fill it exactly
+func readEBMLElements(data []byte, start, end int) ([]ebmlElement, error) {
+	var elements []ebmlElement
+
+	for i := start; i < end; {
+		element, err := readEBMLElement(data, i, end, false)
+		if err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, element)
+		i = element.End
+	}
+
+	return elements, nil
+}
+
+// reads the contents of an unsigned integer element
+func ebmlUint(data []byte) uint64 {
+	var n uint64
+	for _, b := range data {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+// reads the contents of a float element, which is 32 or 64 bits
+func ebmlFloat(data []byte) (float64, bool) {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), true
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), true
+	}
+	return 0, false
+}
+
+// overwrites element with a Void element of the same size, which players skip
+func blankEBMLElement(data []byte, element ebmlElement) {
+	total := element.End - element.Start
+
+	// one byte of ID, then a size taking up as many bytes as needed to fill the rest, up to 8
+	sizeLength := min(8, total-1)
+	size := uint64(total - 1 - sizeLength)
+
+	data[element.Start] = ebmlVoid
+	for k := sizeLength; k >= 1; k-- {
+		data[element.Start+k] = byte(size)
+		size >>= 8
+	}
+	data[element.Start+1] |= 0x80 >> (sizeLength - 1)
+
+	clear(data[element.Start+1+sizeLength : element.End])
+}
+
+/*
+checks the WebM in data: an EBML header saying it's a WebM, then the segment with everything else in
+it. the length comes from the segment info, the picture size from the video tracks
+*/
+func cleanWebM(data []byte) ([]byte, VideoInfo, error) {
+	var info VideoInfo
+
+	header, err := readEBMLElement(data, 0, len(data), false)
+	if err != nil || header.ID != ebmlHeader {
+		return nil, info, ErrUndecodableUpload
+	}
+
+	headerElements, err := readEBMLElements(data, header.DataStart, header.End)
+	if err != nil {
+		return nil, info, ErrUndecodableUpload
+	}
+
+	docType := ""
+	for _, element := range headerElements {
+		if element.ID == ebmlDocType {
+			docType = string(data[element.DataStart:element.End])
+		}
+	}
+	if docType != "webm" {
+		return nil, info, ErrUndecodableUpload
+	}
+
+	segment, err := readEBMLElement(data, header.End, len(data), true)
+	if err != nil || segment.ID != ebmlSegment {
+		return nil, info, ErrUndecodableUpload
+	}
+
+	// clusters of unknown size can't be skipped over without reading every frame in them
+	elements, err := readEBMLElements(data, segment.DataStart, segment.End)
+	if errors.Is(err, errUnknownSizeEBMLElement) {
+		return nil, info, ErrUnknownVideoDuration
+	}
+	if err != nil {
+		return nil, info, ErrUndecodableUpload
+	}
+
+	for _, element := range elements {
+		switch {
+		case element.ID == ebmlInfo:
+			duration, err := webmDuration(data, element)
+			if err != nil {
+				return nil, info, err
+			}
+			info.Duration = duration
+
+		case element.ID == ebmlTracks:
+			width, height, err := webmDimensions(data, element)
+			if err != nil {
+				return nil, info, err
+			}
+			info.Width, info.Height = width, height
+
+		case webmMetadataElements[element.ID]:
+			blankEBMLElement(data, element)
+		}
+	}
+
+	return data[:segment.End], info, nil
+}
+
+// reads the duration out of the segment info, in nanoseconds times the timecode scale
+func webmDuration(data []byte, segmentInfo ebmlElement) (time.Duration, error) {
+	elements, err := readEBMLElements(data, segmentInfo.DataStart, segmentInfo.End)
+	if err != nil {
+		return 0, ErrUndecodableUpload
+	}
+
+	timecodeScale := uint64(1_000_000)
+	duration := 0.0
+	for _, element := range elements {
+		contents := data[element.DataStart:element.End]
+
+		switch element.ID {
+		case ebmlTimecodeScale:
+			timecodeScale = ebmlUint(contents)
+		case ebmlDuration:
+			var ok bool
+			duration, ok = ebmlFloat(contents)
+			if !ok {
+				return 0, ErrUndecodableUpload
+			}
+		}
+	}
+
+	nanoseconds := duration * float64(timecodeScale)
+	if math.IsNaN(nanoseconds) || nanoseconds <= 0 {
+		return 0, nil
+	}
+	if nanoseconds > math.MaxInt64 {
+		return math.MaxInt64, nil
+	}
+	return time.Duration(nanoseconds), nil
+}
+
+// reads the picture size of the biggest video track
+func webmDimensions(data []byte, tracks ebmlElement) (int, int, error) {
+	entries, err := readEBMLElements(data, tracks.DataStart, tracks.End)
+	if err != nil {
+		return 0, 0, ErrUndecodableUpload
+	}
+
+	width, height := 0, 0
+	for _, entry := range entries {
+		if entry.ID != ebmlTrackEntry {
+			continue
+		}
+
+		trackElements, err := readEBMLElements(data, entry.DataStart, entry.End)
+		if err != nil {
+			return 0, 0, ErrUndecodableUpload
+		}
+
+		for _, trackElement := range trackElements {
+			if trackElement.ID != ebmlVideo {
+				continue
+			}
+
+			videoElements, err := readEBMLElements(data, trackElement.DataStart, trackElement.End)
+			if err != nil {
+				return 0, 0, ErrUndecodableUpload
+			}
+
+			for _, videoElement := range videoElements {
+				if videoElement.ID != ebmlPixelWidth && videoElement.ID != ebmlPixelHeight {
+					continue
+				}
+
+				// anything bigger than 32 bits isn't a real size, and would overflow on the way
+				contents := data[videoElement.DataStart:videoElement.End]
+				if len(contents) > 4 {
+					return 0, 0, ErrDimensionsTooLarge
+				}
+
+				if videoElement.ID == ebmlPixelWidth {
+					width = max(width, int(ebmlUint(contents)))
+				} else {
+					height = max(height, int(ebmlUint(contents)))
+				}
+			}
+		}
+	}
+
+	return width, height, nil
+}
@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// an MP4 box of type around contents, with a 32 bit size
+func makeMP4Box(boxType string, contents ...[]byte) []byte {
+	body := bytes.Join(contents, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, boxType...), body...)
+}
+
+// the same with the size given as 64 bits after the type (a size of 1 in the header)
+func makeMP4Box64(boxType string, contents ...[]byte) []byte {
+	body := bytes.Join(contents, nil)
+	box := append(binary.BigEndian.AppendUint32(nil, 1), boxType...)
+	box = binary.BigEndian.AppendUint64(box, uint64(16+len(body)))
+	return append(box, body...)
+}
+
+// the contents of a version 0 movie header box
+func mp4Mvhd(timescale, duration uint32) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], duration)
+	return mvhd
+}
+
+// the contents of a version 0 track header box
+func mp4Tkhd(width, height int) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], uint32(width)<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], uint32(height)<<16)
+	return tkhd
+}
+
+// an MP4 running duration / timescale seconds at width x height, with user data where phones put the location
+func mp4File(timescale, duration uint32, width, height int) []byte {
+	return bytes.Join([][]byte{
+		makeMP4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")),
+		makeMP4Box("moov",
+			makeMP4Box("mvhd", mp4Mvhd(timescale, duration)),
+			makeMP4Box("trak",
+				makeMP4Box("tkhd", mp4Tkhd(width, height)),
+				makeMP4Box("udta", []byte("GPS in the track")),
+			),
+			makeMP4Box("udta", makeMP4Box("meta", []byte("GPS in the movie"))),
+		),
+		makeMP4Box("mdat", []byte("the frames")),
+	}, nil)
+}
+
+// an EBML element with the ID id (length marker included) around contents, with an 8 byte size
+func makeEBML(id uint32, contents ...[]byte) []byte {
+	body := bytes.Join(contents, nil)
+
+	var element []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(element) > 0 {
+			element = append(element, b)
+		}
+	}
+
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(body)))
+	size[0] = 0x01
+	return append(append(element, size...), body...)
+}
+
+// the same with a size that's unknown
+func makeEBMLUnknownSize(id uint32, contents ...[]byte) []byte {
+	element := makeEBML(id, contents...)
+	sizeStart := len(element) - len(bytes.Join(contents, nil)) - 8
+	copy(element[sizeStart+1:], bytes.Repeat([]byte{0xff}, 7))
+	return element
+}
+
+func ebmlUintBytes(n uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, n)
+}
+
+func ebmlFloatBytes(f float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(f))
+}
+
+const webmCluster = 0x1F43B675
+
+// the EBML header of a WebM and the segment info saying it runs for milliseconds
+func webmStart(docType string, milliseconds float64) ([]byte, []byte) {
+	header := makeEBML(ebmlHeader, makeEBML(ebmlDocType, []byte(docType)))
+	info := makeEBML(ebmlInfo,
+		makeEBML(ebmlTimecodeScale, ebmlUintBytes(1_000_000)),
+		makeEBML(ebmlDuration, ebmlFloatBytes(milliseconds)),
+	)
+	return header, info
+}
+
+// a WebM running for milliseconds at width x height, with tags where the location would go
+func webmFile(milliseconds float64, width, height uint32) []byte {
+	header, info := webmStart("webm", milliseconds)
+	return append(header, makeEBML(ebmlSegment,
+		info,
+		makeEBML(ebmlTracks, makeEBML(ebmlTrackEntry, makeEBML(ebmlVideo,
+			makeEBML(ebmlPixelWidth, ebmlUintBytes(width)),
+			makeEBML(ebmlPixelHeight, ebmlUintBytes(height)),
+		))),
+		makeEBML(ebmlTags, []byte("GPS in the tags")),
+		makeEBML(webmCluster, []byte("the frames")),
+	)...)
+}
+
+func setVideoLimits() {
+	LoadConfig()
+	Cfg.UploadMaxWidth, Cfg.UploadMaxHeight = 1920, 1080
+	Cfg.UploadMaxDuration = time.Minute
+}
+
+// metadata comes out as padding of the same size, the rest of the file stays as it was
+func TestCleanVideoBlanksMetadata(t *testing.T) {
+	setVideoLimits()
+
+	original := mp4File(1000, 5000, 640, 360)
+	cleaned, err := CleanVideo(bytes.Clone(original), "mp4")
+	if err != nil {
+		t.Fatalf("CleanVideo() of an MP4 = %v", err)
+	}
+	if len(cleaned) != len(original) || bytes.Contains(cleaned, []byte("GPS")) {
+		t.Fatalf("cleaned MP4 (%d bytes of %d) still has its metadata: %q", len(cleaned), len(original), cleaned)
+	}
+	moov := makeMP4Box("moov",
+		makeMP4Box("mvhd", mp4Mvhd(1000, 5000)),
+		makeMP4Box("trak", makeMP4Box("tkhd", mp4Tkhd(640, 360)), makeMP4Box("free", make([]byte, 16))),
+		makeMP4Box("free", make([]byte, 24)),
+	)
+	if !bytes.Contains(cleaned, moov) {
+		t.Errorf("cleaned MP4 = %q, want the metadata turned into free boxes", cleaned)
+	}
+
+	original = webmFile(5000, 640, 360)
+	withTrailer := append(bytes.Clone(original), "trailing junk"...)
+	cleaned, err = CleanVideo(withTrailer, "webm")
+	if err != nil {
+		t.Fatalf("CleanVideo() of a WebM = %v", err)
+	}
+	if len(cleaned) != len(original) || bytes.Contains(cleaned, []byte("GPS")) {
+		t.Fatalf("cleaned WebM (%d bytes of %d) still has its metadata: %q", len(cleaned), len(original), cleaned)
+	}
+
+	// the tags are a Void element now, spanning exactly what they did before
+	header, _ := readEBMLElement(cleaned, 0, len(cleaned), false)
+	segment, _ := readEBMLElement(cleaned, header.End, len(cleaned), false)
+	elements, err := readEBMLElements(cleaned, segment.DataStart, segment.End)
+	if err != nil || len(elements) != 4 {
+		t.Fatalf("cleaned WebM segment = %+v, %v", elements, err)
+	}
+	if elements[2].ID != ebmlVoid || elements[3].ID != webmCluster {
+		t.Errorf("cleaned WebM segment = %+v, want the tags turned into a Void element", elements)
+	}
+}
+
+func TestCleanVideoRejects(t *testing.T) {
+	setVideoLimits()
+
+	mp4 := mp4File(1000, 5000, 640, 360)
+	webm := webmFile(5000, 640, 360)
+	webmHeader, webmInfo := webmStart("webm", 5000)
+	matroskaHeader, _ := webmStart("matroska", 5000)
+
+	for _, c := range []struct {
+		name     string
+		typeName string
+		data     []byte
+		want     error
+	}{
+		{"MP4", "mp4", mp4, nil},
+		{"MP4, 64 bit box size", "mp4", append(mp4[:len(mp4)-18], makeMP4Box64("mdat", []byte("the frames"))...), nil},
+		{"MP4, truncated box", "mp4", mp4[:len(mp4)-1], ErrUndecodableUpload},
+		{"MP4, 64 bit box size past the end", "mp4", append(mp4[:len(mp4)-18], makeMP4Box64("mdat", []byte("the frames"))[:20]...), ErrUndecodableUpload},
+		{"MP4, not starting with ftyp", "mp4", mp4[len(mp4)-18:], ErrUndecodableUpload},
+		{"MP4, zero timescale", "mp4", mp4File(0, 5000, 640, 360), ErrUndecodableUpload},
+		{"MP4, unknown duration", "mp4", mp4File(1000, math.MaxUint32, 640, 360), ErrUnknownVideoDuration},
+		{"MP4, too long", "mp4", mp4File(1000, 61_000, 640, 360), ErrVideoTooLong},
+		{"MP4, too big", "mp4", mp4File(1000, 5000, 3840, 2160), ErrDimensionsTooLarge},
+		{"MP4, no video track", "mp4", mp4File(1000, 5000, 0, 0), ErrUndecodableUpload},
+		{"WebM", "webm", webm, nil},
+		{"WebM, truncated element", "webm", webm[:len(webm)-1], ErrUndecodableUpload},
+		{"WebM, not a WebM", "webm", append(matroskaHeader, webm[len(webmHeader):]...), ErrUndecodableUpload},
+		{"WebM, unknown-size cluster", "webm", append(webmHeader, makeEBMLUnknownSize(ebmlSegment,
+			webmInfo,
+			makeEBML(ebmlTracks, makeEBML(ebmlTrackEntry, makeEBML(ebmlVideo,
+				makeEBML(ebmlPixelWidth, ebmlUintBytes(640)),
+				makeEBML(ebmlPixelHeight, ebmlUintBytes(360)),
+			))),
+			makeEBMLUnknownSize(webmCluster, []byte("frames as they're recorded")),
+		)...), ErrUnknownVideoDuration},
+		{"WebM, no duration", "webm", webmFile(0, 640, 360), ErrUnknownVideoDuration},
+		{"WebM, too long", "webm", webmFile(61_000, 640, 360), ErrVideoTooLong},
+		{"WebM, too big", "webm", webmFile(5000, 3840, 2160), ErrDimensionsTooLarge},
+		{"WebM, width over 32 bits", "webm", append(webmHeader, makeEBML(ebmlSegment,
+			webmInfo,
+			makeEBML(ebmlTracks, makeEBML(ebmlTrackEntry, makeEBML(ebmlVideo,
+				makeEBML(ebmlPixelWidth, binary.BigEndian.AppendUint64(nil, 640)),
+				makeEBML(ebmlPixelHeight, ebmlUintBytes(360)),
+			))),
+		)...), ErrDimensionsTooLarge},
+		{"neither", "avi", mp4, ErrUnsupportedUpload},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := CleanVideo(bytes.Clone(c.data), c.typeName)
+			if !errors.Is(err, c.want) || (c.want == nil && err != nil) {
+				t.Fatalf("CleanVideo() = %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+// sizes written into the file mustn't make anything index out of it
+func FuzzCleanVideo(f *testing.F) {
+	setVideoLimits()
+
+	f.Add(mp4File(1000, 5000, 640, 360), false)
+	f.Add(makeMP4Box64("ftyp", []byte("isom")), false)
+	f.Add(webmFile(5000, 640, 360), true)
+	f.Add(makeEBMLUnknownSize(ebmlHeader, makeEBML(ebmlDocType, []byte("webm"))), true)
+
+	f.Fuzz(func(t *testing.T, data []byte, webm bool) {
+		typeName := "mp4"
+		if webm {
+			typeName = "webm"
+		}
+
+		cleaned, err := CleanVideo(bytes.Clone(data), typeName)
+		if err == nil && len(cleaned) > len(data) {
+			t.Fatalf("cleaned video grew from %d to %d bytes", len(data), len(cleaned))
+		}
+	})
+}
@@ -1,21 +1,75 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"html/template"
 	"mmiv/controller"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 func main() {
+	pendingMigrations := flag.Bool("pending-migrations", false, "print pending database migrations without applying them and exit")
+	checkUploads := flag.Bool("check-uploads", false, "report orphaned uploads and posts pointing at missing uploads, then exit")
+	fixUploads := flag.Bool("fix-uploads", false, "remove orphaned uploads and clear posts pointing at missing uploads, then exit")
+	backfillThumbnails := flag.Bool("backfill-thumbnails", false, "create thumbnails for uploads that don't have one yet, then exit")
+	flag.Parse()
+
 	controller.LoadConfig()
+
+	if *pendingMigrations {
+		controller.ConnectSQL()
+		defer controller.CloseSQL()
+
+		if err := controller.PrintPendingMigrations(); err != nil {
+			fmt.Println("Error: Could not check pending migrations:", err)
+		}
+		return
+	}
+
+	// everything past this point reads or writes uploads, checking migrations doesn't need the storage
+	controller.OpenStorage()
+
+	if *checkUploads || *fixUploads {
+		controller.OpenSQL()
+		defer controller.CloseSQL()
+
+		report, err := controller.CheckUploads(*fixUploads)
+		if err != nil {
+			fmt.Println("Error: Could not check uploads:", err)
+			return
+		}
+		controller.PrintUploadReport(report)
+		return
+	}
+
+	if *backfillThumbnails {
+		controller.OpenSQL()
+		defer controller.CloseSQL()
+
+		created, err := controller.BackfillThumbnails()
+		if err != nil {
+			fmt.Println("Error: Could not backfill thumbnails:", err)
+		}
+		fmt.Printf("Created %d thumbnail(s)\n", created)
+		return
+	}
+
 	mux := http.NewServeMux()
 
 	controller.OpenSQL()
 	defer controller.CloseSQL()
 
+	// sweep expired sessions out of the database every hour
+	controller.StartSessionSweeper(time.Hour)
+	controller.StartLoginThrottlePruner(time.Hour)
+	controller.StartRateLimitPruner(10 * time.Minute)
+	controller.StartTrashPurger(time.Hour)
+	controller.StartUploadChecker(24 * time.Hour)
+
 	/*
 		TODO: figure out how to solve the problem of valid html pages requiring exact pathing:
 		i.e whenever I type in "/home" for example, the pathing works, although a trailing slash will redir. to 404
@@ -26,14 +80,14 @@ func main() {
 		path := r.URL.Path[len("/static/img/"):]
 		filePath := "./static/img/" + path
 
-		// give permission to every admin rank to view dir raw
-		if controller.DoesUserMatchRank(r, "2") {
+		// give permission to everyone with dashboard access to view dir raw
+		if controller.HasPermission(r, controller.PermViewDashboard) {
 			http.ServeFile(w, r, filePath)
 			return
 		}
 
 		// ...however give every user the permission to view only the files themselves
-		if controller.DoesUserMatchRank(r, "1") {
+		if controller.HasPermission(r, controller.PermRead) {
 			if path == "" || strings.HasSuffix(r.URL.Path, "/") {
 				http.Redirect(w, r, "/404", http.StatusSeeOther)
 				return
@@ -52,31 +106,26 @@ func main() {
 		http.Redirect(w, r, "/404", http.StatusSeeOther)
 	})
 
-	// uploads directory for handling on-platform uploads
+	// uploads directory for handling on-platform uploads, served from wherever controller.Uploads keeps them
 	mux.HandleFunc("/uploads/", func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path[len("/uploads/"):]
-		filePath := "./uploads/" + path
 
-		// give permission to every admin rank to view dir raw
-		if controller.DoesUserMatchRank(r, "2") {
-			http.ServeFile(w, r, filePath)
+		// give permission to everyone with dashboard access to view dir raw
+		if path == "" && controller.HasPermission(r, controller.PermViewDashboard) {
+			controller.ServeUploadListing(w)
 			return
 		}
 
 		// ...however give every user the permission to view only the files themselves
-		if controller.DoesUserMatchRank(r, "1") {
+		if controller.HasPermission(r, controller.PermRead) {
 			if path == "" || strings.HasSuffix(r.URL.Path, "/") {
 				http.Redirect(w, r, "/404", http.StatusSeeOther)
 				return
 			}
 
-			info, err := os.Stat(filePath)
-			if err != nil || info.IsDir() {
+			if !controller.ServeUpload(w, r, path) {
 				http.Redirect(w, r, "/404", http.StatusSeeOther)
-				return
 			}
-
-			http.ServeFile(w, r, filePath)
 			return
 		}
 
@@ -88,7 +137,7 @@ func main() {
 
 	// home directory for handling access to main website stuff
 	mux.HandleFunc("/static/home/", func(w http.ResponseWriter, r *http.Request) {
-		if !controller.DoesUserMatchRank(r, "1") {
+		if !controller.HasPermission(r, controller.PermRead) {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
@@ -99,7 +148,7 @@ func main() {
 
 	// private directory for handling access to stuff the average user / stranger shouldn't prolly access
 	mux.HandleFunc("/static/private/", func(w http.ResponseWriter, r *http.Request) {
-		if !controller.DoesUserMatchRank(r, "2") {
+		if !controller.HasPermission(r, controller.PermViewDashboard) {
 			http.Redirect(w, r, "/404", http.StatusSeeOther)
 			return
 		}
@@ -110,7 +159,7 @@ func main() {
 
 	// 404 directory for handling access to custom 404 page
 	mux.HandleFunc("/static/404/", func(w http.ResponseWriter, r *http.Request) {
-		if !controller.DoesUserMatchRank(r, "1") {
+		if !controller.HasPermission(r, controller.PermRead) {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
@@ -147,8 +196,6 @@ func main() {
 			fmt.Println("Error: Could not query ID of user! Does not exist?")
 		}
 
-		isAdmin := controller.DoesUserMatchRank(r, "2")
-
 		if token == nil || username == "" {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
@@ -161,11 +208,14 @@ func main() {
 		}
 
 		tmpl.Execute(w, map[string]any{
-			"Username": username,
-			"Id":       id,
-			"CSS":      "index.css",
-			"JS":       "index.js",
-			"IsAdmin":  isAdmin,
+			"Username":    username,
+			"Id":          id,
+			"CSS":         "index.css",
+			"JS":          "index.js",
+			"IsAdmin":     controller.HasPermission(r, controller.PermViewDashboard),
+			"CanPin":      controller.HasPermission(r, controller.PermPin),
+			"CanLock":     controller.HasPermission(r, controller.PermLock),
+			"CanPostHTML": controller.HasPermission(r, controller.PermPostHTML),
 		})
 	})
 
@@ -176,7 +226,7 @@ func main() {
 
 	// dashboard page serve function
 	mux.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
-		if !controller.DoesUserMatchRank(r, "2") {
+		if !controller.HasPermission(r, controller.PermViewDashboard) {
 			http.Redirect(w, r, "/404", http.StatusSeeOther)
 			return
 		}
@@ -184,25 +234,49 @@ func main() {
 		http.ServeFile(w, r, "./static/private/dashboard.html")
 	})
 
-	// api calls
+	// api calls, the ones that could be spammed are wrapped with a rate limit (see ratelimitController.go)
 	mux.HandleFunc("/api/login", func(w http.ResponseWriter, r *http.Request) {
 		controller.Login(w, r)
 	})
 
+	mux.HandleFunc("/api/register", controller.RateLimit("register", func(w http.ResponseWriter, r *http.Request) {
+		controller.Register(w, r)
+	}))
+
 	mux.HandleFunc("/api/logout", func(w http.ResponseWriter, r *http.Request) {
 		controller.Logout(w, r)
 	})
 
-	mux.HandleFunc("/api/addPost", func(w http.ResponseWriter, r *http.Request) {
-		controller.AddPost(w, r)
+	mux.HandleFunc("/api/logoutAll", func(w http.ResponseWriter, r *http.Request) {
+		controller.LogoutAll(w, r)
+	})
+
+	mux.HandleFunc("/api/revokeUserSessions", func(w http.ResponseWriter, r *http.Request) {
+		controller.RevokeUserSessionsAdmin(w, r)
 	})
 
+	mux.HandleFunc("/api/addPost", controller.RateLimit("addPost", func(w http.ResponseWriter, r *http.Request) {
+		controller.AddPost(w, r)
+	}))
+
 	mux.HandleFunc("/api/deletePost", func(w http.ResponseWriter, r *http.Request) {
 		controller.DeletePost(w, r)
 	})
 
-	mux.HandleFunc("/api/requestPost", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/requestPost", controller.RateLimit("requestPost", func(w http.ResponseWriter, r *http.Request) {
 		controller.RequestPost(w, r)
+	}))
+
+	mux.HandleFunc("/api/requestTrash", func(w http.ResponseWriter, r *http.Request) {
+		controller.RequestTrash(w, r)
+	})
+
+	mux.HandleFunc("/api/restoreTrash", func(w http.ResponseWriter, r *http.Request) {
+		controller.RestoreTrash(w, r)
+	})
+
+	mux.HandleFunc("/api/purgeTrash", func(w http.ResponseWriter, r *http.Request) {
+		controller.PurgeTrash(w, r)
 	})
 
 	mux.HandleFunc("/api/pinPost", func(w http.ResponseWriter, r *http.Request) {
@@ -213,26 +287,82 @@ func main() {
 		controller.LockPost(w, r)
 	})
 
-	mux.HandleFunc("/api/addComment", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/addComment", controller.RateLimit("addComment", func(w http.ResponseWriter, r *http.Request) {
 		controller.AddComment(w, r)
-	})
+	}))
 
 	mux.HandleFunc("/api/deleteComment", func(w http.ResponseWriter, r *http.Request) {
 		controller.DeleteComment(w, r)
 	})
 
-	mux.HandleFunc("/api/requestComment", func(w http.ResponseWriter, r *http.Request) {
-		controller.RequestComment(w, r)
+	mux.HandleFunc("/api/editPost", func(w http.ResponseWriter, r *http.Request) {
+		controller.EditPost(w, r)
+	})
+
+	mux.HandleFunc("/api/editComment", func(w http.ResponseWriter, r *http.Request) {
+		controller.EditComment(w, r)
+	})
+
+	mux.HandleFunc("/api/requestRevisions", func(w http.ResponseWriter, r *http.Request) {
+		controller.RequestRevisions(w, r)
 	})
 
+	mux.HandleFunc("/api/requestComment", controller.RateLimit("requestComment", func(w http.ResponseWriter, r *http.Request) {
+		controller.RequestComment(w, r)
+	}))
+
 	mux.HandleFunc("/api/addUser", func(w http.ResponseWriter, r *http.Request) {
 		controller.AddUser(w, r)
 	})
 
+	mux.HandleFunc("/api/changePassword", func(w http.ResponseWriter, r *http.Request) {
+		controller.ChangePassword(w, r)
+	})
+
+	mux.HandleFunc("/api/resetPassword", func(w http.ResponseWriter, r *http.Request) {
+		controller.ResetPassword(w, r)
+	})
+
 	mux.HandleFunc("/api/deleteUser", func(w http.ResponseWriter, r *http.Request) {
 		controller.DeleteUser(w, r)
 	})
 
+	mux.HandleFunc("/api/listUsers", func(w http.ResponseWriter, r *http.Request) {
+		controller.ListUsers(w, r)
+	})
+
+	mux.HandleFunc("/api/setUserRank", func(w http.ResponseWriter, r *http.Request) {
+		controller.SetUserRank(w, r)
+	})
+
+	mux.HandleFunc("/api/banUser", func(w http.ResponseWriter, r *http.Request) {
+		controller.BanUser(w, r)
+	})
+
+	mux.HandleFunc("/api/unbanUser", func(w http.ResponseWriter, r *http.Request) {
+		controller.UnbanUser(w, r)
+	})
+
+	mux.HandleFunc("/api/listBans", func(w http.ResponseWriter, r *http.Request) {
+		controller.ListBans(w, r)
+	})
+
+	mux.HandleFunc("/api/createInvite", func(w http.ResponseWriter, r *http.Request) {
+		controller.CreateInvite(w, r)
+	})
+
+	mux.HandleFunc("/api/deleteInvite", func(w http.ResponseWriter, r *http.Request) {
+		controller.DeleteInvite(w, r)
+	})
+
+	mux.HandleFunc("/api/requestInvites", func(w http.ResponseWriter, r *http.Request) {
+		controller.RequestInvites(w, r)
+	})
+
+	mux.HandleFunc("/api/requestLockouts", func(w http.ResponseWriter, r *http.Request) {
+		controller.RequestLockouts(w, r)
+	})
+
 	mux.HandleFunc("/api/addAnnouncement", func(w http.ResponseWriter, r *http.Request) {
 		controller.AddAnnouncement(w, r)
 	})
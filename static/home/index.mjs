@@ -1,5 +1,11 @@
 export let currentPosts = new Map;
 
+// infinite scroll state for the post feed, nextCursor is empty once there's nothing left to load
+const postsPerPage = 20;
+let nextCursor = "";
+let loadingMorePosts = false;
+let viewingFeed = false;
+
 class Post {
     constructor({
         id,
@@ -7,6 +13,7 @@ class Post {
         username,
         postcontent,
         imagepath,
+        thumbpath,
         commentcount,
         timestamp,
         pinned,
@@ -14,6 +21,10 @@ class Post {
         canpin,
         canlock,
         hasownership,
+        editedat,
+        canedit,
+        source,
+        rawhtml,
         iscomment,
         clickFunc
     } = {}) {
@@ -23,12 +34,17 @@ class Post {
         this.postcontent = postcontent;
         this.commentcount = commentcount;
         this.imagepath = imagepath;
+        this.thumbpath = thumbpath;
         this.timestamp = timestamp;
         this.pinned = pinned;
         this.locked = locked;
         this.canpin = canpin;
         this.canlock = canlock;
         this.hasownership = hasownership;
+        this.editedat = editedat;
+        this.canedit = canedit;
+        this.source = source;
+        this.rawhtml = rawhtml;
         this.iscomment = iscomment,
         this.clickFunc = clickFunc;
     };
@@ -41,6 +57,8 @@ class Post {
         const isPinned = this.pinned;
         const isLocked = this.locked;
         const isComment = this.iscomment;
+        const source = this.source ?? "";
+        const rawHTML = this.rawhtml ?? false;
 
         // post
 
@@ -94,6 +112,56 @@ class Post {
             });
         };
 
+        let editOption = null;
+        if (this.canedit) {
+            editOption = document.createElement('p');
+            editOption.className = "clickable";
+            editOption.innerText = "Edit";
+
+            let url = '/api/editPost';
+            if (isComment) {
+                url = '/api/editComment';
+            }
+
+            editOption.addEventListener('click', function(e) {
+                e.stopPropagation();
+
+                // the text as it was typed, the rendered one has emoticons and links turned into HTML
+                const currentText = source;
+                const newText = prompt("Edit:", currentText);
+                if (newText === null || newText === currentText) return;
+
+                fetch(url, {
+                    method: "POST",
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({
+                        id: postId,
+                        postcontent: newText,
+                        'reject-sanitize': rawHTML,
+                    })
+                }).then(response => {
+                    if (!response.ok) {
+                        return response.text().then(text => {
+                            throw new Error(errorMessageFromText(text) || response.statusText);
+                        });
+                    };
+
+                    return response.json();
+                }).then(data => {
+                    console.log("Success:", data);
+
+                    if (isComment) {
+                        fetchComments(parentPost);
+                    } else {
+                        fetchPosts();
+                    }
+                }).catch(error => {
+                    console.error("Error:", error);
+                    alert("Edit failed: " + error.message);
+                });
+            });
+        };
+
         let pinOption = null;
         if (this.canpin) {
             pinOption = document.createElement('p');
@@ -202,6 +270,10 @@ class Post {
             headerTitleP.innerHTML += `<img src="/static/img/icons/lock.png" alt="L" class="emoticon"> `
         };
 
+        if (this.editedat !== undefined && this.editedat !== "") {
+            headerTitleP.innerHTML += `<span title="${new Date(this.editedat).toLocaleString()}">(edited)</span> `
+        };
+
         settingButtonP.addEventListener('click', function(e) {
             e.stopPropagation();
 
@@ -228,20 +300,46 @@ class Post {
         contentP.innerHTML = this.postcontent;
 
         let contentImg = null;
-        if (this.imagepath !== null && this.imagepath !== "") {
-            contentImg = document.createElement('img');
+        if (this.imagepath !== null && /\.(webm|mp4)$/i.test(this.imagepath)) {
+            // video clips have no thumbnail, only the start of them is loaded until they're played
+            contentImg = document.createElement('video');
             contentImg.src = this.imagepath;
+            contentImg.controls = true;
+            contentImg.loop = true;
+            contentImg.playsInline = true;
+            contentImg.preload = "metadata";
+            contentImg.classList = 'image-content';
+
+            contentImg.addEventListener('click', function(e) {
+                e.stopPropagation();
+            });
+        } else if (this.imagepath !== null && this.imagepath !== "") {
+            // the feed shows the thumbnail (if there is one), the original is only loaded once opened
+            const imagePath = this.imagepath;
+            const thumbPath = this.thumbpath ? this.thumbpath : this.imagepath;
+            let expanded = false;
+
+            contentImg = document.createElement('img');
+            contentImg.src = thumbPath;
             contentImg.classList = 'image-content clickable';
 
             contentImg.addEventListener('click', function(e) {
                 e.stopPropagation();
                 
-                if (contentImg.style.width === contentImg.naturalWidth + "px") {
+                if (expanded) {
+                    expanded = false;
+                    contentImg.src = thumbPath;
                     contentImg.style.width = "150px";
                     postContentDiv.style.flexDirection = "row";
                     postContentDiv.style.flexWrap = "nowrap";
                 } else {
-                    contentImg.style.width = contentImg.naturalWidth + "px";
+                    expanded = true;
+                    contentImg.src = imagePath;
+                    contentImg.decode().catch(() => {}).then(() => {
+                        if (expanded) {
+                            contentImg.style.width = contentImg.naturalWidth + "px";
+                        };
+                    });
                     postContentDiv.style.flexDirection = "column";
                     postContentDiv.style.flexWrap = "wrap";
                 };
@@ -257,6 +355,9 @@ class Post {
         if (deleteOption !== null) {
             dropdownDiv.appendChild(deleteOption);
         };
+        if (editOption !== null) {
+            dropdownDiv.appendChild(editOption);
+        };
         if (pinOption !== null) {
             dropdownDiv.appendChild(pinOption);
         }
@@ -287,6 +388,90 @@ class Post {
     };
 };
 
+// errors are either plain text or JSON of {"status": "error", "error": "..."}, return the message either way
+function errorMessageFromText(text) {
+    try {
+        const data = JSON.parse(text);
+        if (data.error !== undefined) {
+            return data.error;
+        };
+    } catch (e) {}
+
+    return text;
+};
+
+function feedPost(element) {
+    return new Post({
+        id: element.id,
+        username: element.username,
+        postcontent: element.postcontent,
+        imagepath: element.imagepath,
+        thumbpath: element.thumbpath,
+        commentcount: element.commentcount,
+        timestamp: element.timestamp,
+        pinned: element.pinned,
+        locked: element.locked,
+        canpin: element.canpin,
+        canlock: element.canlock,
+        hasownership: element.hasownership,
+        editedat: element.editedat,
+        canedit: element.canedit,
+        source: element.source,
+        rawhtml: element.rawhtml,
+        iscomment: element.iscomment,
+        clickFunc: function() {
+            fetchComments(element);
+        }
+    });
+};
+
+function requestPostPage(cursor) {
+    const requestFormData = new FormData();
+    requestFormData.append("cursor", cursor);
+    requestFormData.append("amountofpostsrequested", postsPerPage);
+
+    return fetch('/api/requestPost', {
+        method: 'POST',
+        body: requestFormData
+    }).then(response => {
+        if (!response.ok) {
+            throw new Error("Failed");
+        };
+
+        return response.json();
+    });
+};
+
+// appends the page after nextCursor to the feed, called whenever we scroll near the bottom
+function fetchMorePosts() {
+    if (!viewingFeed || loadingMorePosts || nextCursor === "") return;
+    loadingMorePosts = true;
+
+    requestPostPage(nextCursor).then(data => {
+        const content = document.getElementById('content');
+
+        data.posts.forEach((element) => {
+            if (currentPosts.has(element.id)) return;
+
+            const newPost = feedPost(element);
+            currentPosts.set(newPost.id, newPost);
+            content.appendChild(newPost.createElements());
+        });
+
+        nextCursor = data.next_cursor;
+    }).catch(error => {
+        console.error("Error:", error);
+    }).finally(() => {
+        loadingMorePosts = false;
+    });
+};
+
+window.addEventListener('scroll', () => {
+    if (window.innerHeight + window.scrollY >= document.body.offsetHeight - 300) {
+        fetchMorePosts();
+    };
+});
+
 function loadPosts() {
     const content = document.getElementById('content');
     content.innerHTML = "";
@@ -326,8 +511,9 @@ export function fetchPosts() {
             }).then(response => {
                 if (!response.ok) {
                     return response.text().then(text => {
-                        errorText.textContent = text || response.statusText;
-                        throw new Error(text || response.statusText);
+                        const message = errorMessageFromText(text) || response.statusText;
+                        errorText.textContent = message;
+                        throw new Error(message);
                     });
                 }
 
@@ -343,48 +529,21 @@ export function fetchPosts() {
         }
     });
 
-    // DISPLAY POST NUMBER AND AMOUNT OF POSTS CODE, MOVE THIS TO
-    // A SMALL UI ELEMENT LATER POTENTIALLY
-    const requestFormData = new FormData();
-    requestFormData.append("displayfrompostnumber", 0);
-    requestFormData.append("amountofpostsrequested", 20);
+    // first page of the feed, the rest is loaded through fetchMorePosts() while scrolling
+    viewingFeed = true;
+    nextCursor = "";
 
-    fetch('/api/requestPost', {
-        method: 'POST',
-        body: requestFormData
-    }).then(response => {
-        if (!response.ok) {
-            throw new Error("Failed");
-        };
-        
-        return response.json();
-    }).then(data => {
+    requestPostPage("").then(data => {
         console.log("Success:", data);
         
         currentPosts.clear();
-        data.forEach((element) => {
-            const newPost = new Post({
-                id: element.id,
-                username: element.username,
-                postcontent: element.postcontent,
-                imagepath: element.imagepath,
-                commentcount: element.commentcount,
-                timestamp: element.timestamp,
-                pinned: element.pinned,
-                locked: element.locked,
-                canpin: element.canpin,
-                canlock: element.canlock,
-                hasownership: element.hasownership,
-                iscomment: element.iscomment,
-                clickFunc: function() {
-                    fetchComments(element);
-                }
-            });
-
+        data.posts.forEach((element) => {
+            const newPost = feedPost(element);
             currentPosts.set(newPost.id, newPost);
         });
 
         loadPosts();
+        nextCursor = data.next_cursor;
     }).catch(error => {
         console.error("Error:", error);
     });
@@ -409,6 +568,7 @@ export function fetchPosts() {
                 username: element.username,
                 postcontent: element.postcontent,
                 imagepath: element.imagepath,
+                thumbpath: element.thumbpath,
                 commentcount: element.commentcount,
                 timestamp: element.timestamp,
                 pinned: element.pinned,
@@ -416,6 +576,10 @@ export function fetchPosts() {
                 canpin: element.canpin,
                 canlock: element.canlock,
                 hasownership: element.hasownership,
+                editedat: element.editedat,
+                canedit: element.canedit,
+                source: element.source,
+                rawhtml: element.rawhtml,
                 iscomment: element.iscomment,
                 clickFunc: function() {
                     fetchComments(element);
@@ -462,8 +626,9 @@ function fetchComments(postParent) {
             }).then(response => {
                 if (!response.ok) {
                     return response.text().then(text => {
-                        errorText.textContent = text || response.statusText;
-                        throw new Error(text || response.statusText);
+                        const message = errorMessageFromText(text) || response.statusText;
+                        errorText.textContent = message;
+                        throw new Error(message);
                     });
                 }
 
@@ -481,6 +646,7 @@ function fetchComments(postParent) {
     
     // const optionsMenu = document.getElementById('option-menu');
     // optionsMenu.style.display = "none";
+    viewingFeed = false;
 
     fetch('/api/requestComment', {
         method: 'POST',
@@ -508,6 +674,7 @@ function fetchComments(postParent) {
                     username: element.username,
                     postcontent: element.postcontent,
                     imagepath: element.imagepath,
+                    thumbpath: element.thumbpath,
                     commentcount: element.commentcount,
                     timestamp: element.timestamp,
                     pinned: element.pinned,
@@ -515,6 +682,10 @@ function fetchComments(postParent) {
                     canpin: element.canpin,
                     canlock: element.canlock,
                     hasownership: element.hasownership,
+                    editedat: element.editedat,
+                    canedit: element.canedit,
+                    source: element.source,
+                    rawhtml: element.rawhtml,
                     iscomment: element.iscomment,
                 });
 
@@ -592,6 +763,48 @@ function logoutButton() {
     });
 };
 
+function passwordButton() {
+    document.getElementById('password-button').addEventListener('click', function() {
+        const currentPassword = prompt("Current password:");
+        if (currentPassword === null) return;
+        const newPassword = prompt("New password:");
+        if (newPassword === null) return;
+
+        fetch('/api/changePassword', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                currentpassword: currentPassword,
+                newpassword: newPassword,
+            }),
+        }).then(response => response.json().then(data => {
+            if (!response.ok) throw new Error(data.error || response.statusText);
+            return data;
+        })).then(() => {
+            alert("Password changed, your other sessions have been logged out.");
+        }).catch(error => {
+            alert("Password change failed: " + error.message);
+        });
+    });
+};
+
+function logoutAllButton() {
+    document.getElementById('logout-all-button').addEventListener('click', function() {
+        fetch('/api/logoutAll', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+        }).then(response => {
+            if (!response.ok) {
+                throw new Error("Failed");
+            };
+            
+            return response.json();
+        }).then(() => {
+            window.location.href = "/login";
+        });
+    });
+};
+
 function setupDraggableForm({
     grabBarLabelText = null,
     formButtonLabelText = null,
@@ -650,7 +863,9 @@ function setupDraggableForm({
 document.addEventListener("DOMContentLoaded", (event) => {
     returnButton();
     dashboardButton();
+    passwordButton();
     logoutButton();
+    logoutAllButton();
     fetchPosts();
     fetchAnnouncement();
 });
\ No newline at end of file
@@ -20,6 +20,33 @@ function fetchFunction(
     .catch(error => catchFunc(error));
 };
 
+// used whenever an admin has reset the password, a new one has to be chosen before logging in
+function changePassword(username, currentPassword) {
+    const newPassword = prompt("Your password was reset, please choose a new one:");
+    if (newPassword === null) return;
+
+    fetch("/api/changePassword", {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            username: username,
+            currentpassword: currentPassword,
+            newpassword: newPassword
+        })
+    })
+    .then(response => response.json().then(data => {
+        if (!response.ok) throw new Error(data.error || response.statusText);
+        return data;
+    }))
+    .then(data => {
+        window.location.href = data.redirect;
+    })
+    .catch(error => {
+        console.error("Password change failed:", error);
+        alert("Password change failed: " + error.message);
+    });
+};
+
 document.addEventListener("DOMContentLoaded", function () {
     const form = document.getElementById("login-form");
 
@@ -28,23 +55,64 @@ document.addEventListener("DOMContentLoaded", function () {
         const username = document.getElementById("username").value;
         const password = document.getElementById("password").value;
 
-        fetchFunction(
-            "/api/login", 
-            'POST', 
-            { 'Content-Type': 'application/json' }, 
-            { 
-                username: username, 
+        fetch("/api/login", {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                username: username,
                 password: password
-            },
-            (response) => response.json(),
-            (data) => {
-                window.location.href = "/";
-            },
-            (error) => {
-                console.error("Login failed:", error);
-                alert("Login failed: Invalid username or password.");
+            })
+        })
+        .then(response => {
+            if (response.status === 403) {
+                return response.json().then(data => {
+                    if (data.status === "password_change_required") {
+                        changePassword(username, password);
+                        return;
+                    }
+                    throw new Error(data.error || response.statusText);
+                });
+            }
+            if (response.status === 429) {
+                return response.json().then(data => {
+                    throw new Error(data.error);
+                });
             }
-        );
+            if (!response.ok) throw new Error("Invalid username or password.");
+
+            window.location.href = "/";
+        })
+        .catch(error => {
+            console.error("Login failed:", error);
+            alert("Login failed: " + error.message);
+        });
+    });
+
+    document.getElementById("register-button").addEventListener("click", function () {
+        const username = document.getElementById("username").value;
+        const password = document.getElementById("password").value;
+        const invite = document.getElementById("invite").value;
+
+        fetch("/api/register", {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                username: username,
+                password: password,
+                invite: invite
+            })
+        })
+        .then(response => response.json().then(data => {
+            if (!response.ok) throw new Error(data.error || response.statusText);
+            return data;
+        }))
+        .then(data => {
+            window.location.href = data.redirect;
+        })
+        .catch(error => {
+            console.error("Register failed:", error);
+            alert("Register failed: " + error.message);
+        });
     });
 });
 
@@ -56,7 +124,7 @@ fetchFunction(
     { 
         username: "sannu", 
         password: "admin",
-        rank: "2",
+        role: "admin",
     }
 )
     */
\ No newline at end of file
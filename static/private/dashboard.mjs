@@ -95,10 +95,458 @@ async function emoticonHandler() {
     });
 }
 
+async function sessionHandler() {
+    const sessionUsername = document.getElementById('session-username');
+
+    document.getElementById('revoke-sessions-button').addEventListener('click', function() {
+        fetch('/api/revokeUserSessions', {
+            method: "POST",
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                username: sessionUsername.value,
+            }),
+        }).then(res => {
+            if (!res.ok) {
+                throw new Error("Failed");
+            }
+            return res.json();
+        }).then(data => {
+            console.log("Success:", data);
+        }).catch(error => {
+            console.error("Error:", error);
+        });
+    });
+}
+
+let userPage = 1;
+let userPageCount = 1;
+
+function userAction(url, body) {
+    const userResult = document.getElementById('user-result');
+
+    fetch(url, {
+        method: "POST",
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body),
+    }).then(res => res.json().then(data => {
+        if (!res.ok) throw new Error(data.error || res.statusText);
+        return data;
+    })).then(() => {
+        userResult.textContent = "";
+        loadUsers();
+    }).catch(error => {
+        userResult.textContent = error.message;
+        console.error("Error:", error);
+    });
+}
+
+function loadUsers() {
+    const userList = document.getElementById('user-list');
+    const userPageInfo = document.getElementById('user-page-info');
+    const search = document.getElementById('user-search').value;
+
+    const params = new URLSearchParams({ page: userPage, search: search });
+    fetch('/api/listUsers?' + params.toString(), {
+        method: 'GET',
+    }).then(res => {
+        if (!res.ok) {
+            throw new Error("Failed");
+        }
+        return res.json();
+    }).then(data => {
+        userList.innerHTML = "";
+        userPageCount = Math.max(1, Math.ceil(data.total / data.pagesize));
+        userPageInfo.innerText = `Page ${data.page} of ${userPageCount} (${data.total} users)`;
+
+        data.users.forEach((user) => {
+            const userP = document.createElement('p');
+            const lastLogin = user.lastlogin === "" ? "never" : user.lastlogin;
+            userP.innerText = `${user.username} | ${user.postcount} posts, ${user.commentcount} comments | last login ${lastLogin} `;
+
+            const roleInput = document.createElement('input');
+            roleInput.type = "text";
+            roleInput.value = user.role;
+
+            const roleButton = document.createElement('button');
+            roleButton.type = "button";
+            roleButton.innerText = "Set Role";
+            roleButton.addEventListener('click', function() {
+                userAction('/api/setUserRank', {
+                    username: user.username,
+                    role: roleInput.value,
+                });
+            });
+
+            const deleteButton = document.createElement('button');
+            deleteButton.type = "button";
+            deleteButton.innerText = "Delete";
+            deleteButton.addEventListener('click', function() {
+                if (!confirm(`Delete ${user.username}? Their posts and comments are kept as [deleted].`)) {
+                    return;
+                }
+
+                userAction('/api/deleteUser', {
+                    username: user.username,
+                    mode: "keep",
+                });
+            });
+
+            const purgeButton = document.createElement('button');
+            purgeButton.type = "button";
+            purgeButton.innerText = "Purge";
+            purgeButton.addEventListener('click', function() {
+                if (!confirm(`Delete ${user.username} together with all of their posts, comments and uploads?`)) {
+                    return;
+                }
+
+                userAction('/api/deleteUser', {
+                    username: user.username,
+                    mode: "purge",
+                });
+            });
+
+            userP.appendChild(roleInput);
+            userP.appendChild(roleButton);
+            userP.appendChild(deleteButton);
+            userP.appendChild(purgeButton);
+            userList.appendChild(userP);
+        });
+    }).catch(error => {
+        console.error("Error:", error);
+    });
+}
+
+async function userHandler() {
+    document.getElementById('user-search-button').addEventListener('click', function() {
+        userPage = 1;
+        loadUsers();
+    });
+
+    document.getElementById('user-prev-button').addEventListener('click', function() {
+        if (userPage > 1) {
+            userPage--;
+            loadUsers();
+        }
+    });
+
+    document.getElementById('user-next-button').addEventListener('click', function() {
+        if (userPage < userPageCount) {
+            userPage++;
+            loadUsers();
+        }
+    });
+
+    loadUsers();
+}
+
+function banAction(url, body) {
+    const banResult = document.getElementById('ban-result');
+
+    fetch(url, {
+        method: "POST",
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body),
+    }).then(res => res.json().then(data => {
+        if (!res.ok) throw new Error(data.error || res.statusText);
+        return data;
+    })).then(() => {
+        banResult.textContent = "";
+        loadBans();
+    }).catch(error => {
+        banResult.textContent = error.message;
+        console.error("Error:", error);
+    });
+}
+
+function loadBans() {
+    const banList = document.getElementById('ban-list');
+
+    fetch('/api/listBans', {
+        method: 'GET',
+    }).then(res => {
+        if (!res.ok) {
+            throw new Error("Failed");
+        }
+        return res.json();
+    }).then(data => {
+        banList.innerHTML = "";
+
+        if (data.length === 0) {
+            banList.innerText = "No active bans";
+        }
+
+        data.forEach((ban) => {
+            const banP = document.createElement('p');
+            const expires = ban.expiresat === "" ? "never" : ban.expiresat;
+            const kind = ban.readonly ? "read-only" : "full";
+            banP.innerText = `${ban.username} | ${kind} | expires ${expires} | by ${ban.createdby} at ${ban.createdat} | ${ban.reason} `;
+
+            const unbanButton = document.createElement('button');
+            unbanButton.type = "button";
+            unbanButton.innerText = "Unban";
+            unbanButton.addEventListener('click', function() {
+                banAction('/api/unbanUser', {
+                    username: ban.username,
+                });
+            });
+
+            banP.appendChild(unbanButton);
+            banList.appendChild(banP);
+        });
+    }).catch(error => {
+        console.error("Error:", error);
+    });
+}
+
+async function banHandler() {
+    document.getElementById('ban-button').addEventListener('click', function() {
+        banAction('/api/banUser', {
+            username: document.getElementById('ban-username').value,
+            reason: document.getElementById('ban-reason').value,
+            durationhours: parseInt(document.getElementById('ban-duration').value) || 0,
+            readonly: document.getElementById('ban-readonly').checked,
+        });
+    });
+
+    loadBans();
+}
+
+function loadInvites() {
+    const inviteList = document.getElementById('invite-list');
+
+    fetch('/api/requestInvites', {
+        method: 'GET',
+    }).then(res => {
+        if (!res.ok) {
+            throw new Error("Failed");
+        }
+        return res.json();
+    }).then(data => {
+        inviteList.innerHTML = "";
+
+        data.forEach((invite) => {
+            const inviteP = document.createElement('p');
+            const expires = invite.expiresat === "" ? "never" : invite.expiresat;
+            const redeemedBy = invite.redeemedby.length > 0 ? invite.redeemedby.join(", ") : "nobody";
+            inviteP.innerText = `${invite.code} | role ${invite.role} | ${invite.uses}/${invite.maxuses} uses | expires ${expires} | by ${invite.createdby} | redeemed by ${redeemedBy} `;
+
+            const deleteButton = document.createElement('button');
+            deleteButton.type = "button";
+            deleteButton.innerText = "Delete";
+            deleteButton.addEventListener('click', function() {
+                fetch('/api/deleteInvite', {
+                    method: "POST",
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({
+                        code: invite.code,
+                    }),
+                }).then(res => {
+                    if (!res.ok) {
+                        throw new Error("Failed");
+                    }
+                    return res.json();
+                }).then(() => {
+                    loadInvites();
+                }).catch(error => {
+                    console.error("Error:", error);
+                });
+            });
+
+            inviteP.appendChild(deleteButton);
+            inviteList.appendChild(inviteP);
+        });
+    }).catch(error => {
+        console.error("Error:", error);
+    });
+}
+
+async function inviteHandler() {
+    const inviteCreated = document.getElementById('invite-created');
+
+    document.getElementById('create-invite-button').addEventListener('click', function() {
+        fetch('/api/createInvite', {
+            method: "POST",
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                maxuses: parseInt(document.getElementById('invite-max-uses').value) || 1,
+                expiresinhours: parseInt(document.getElementById('invite-expires').value) || 0,
+                role: document.getElementById('invite-role').value,
+            }),
+        }).then(res => res.json().then(data => {
+            if (!res.ok) throw new Error(data.error || res.statusText);
+            return data;
+        })).then(data => {
+            inviteCreated.textContent = "Created invite: " + data.code;
+            loadInvites();
+        }).catch(error => {
+            inviteCreated.textContent = error.message;
+            console.error("Error:", error);
+        });
+    });
+
+    loadInvites();
+}
+
+function trashAction(url, id) {
+    const trashResult = document.getElementById('trash-result');
+
+    fetch(url, {
+        method: "POST",
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            id: id,
+        }),
+    }).then(res => res.json().then(data => {
+        if (!res.ok) throw new Error(data.error || res.statusText);
+        return data;
+    })).then(() => {
+        trashResult.textContent = "";
+        loadTrash();
+    }).catch(error => {
+        trashResult.textContent = error.message;
+        console.error("Error:", error);
+    });
+}
+
+function loadTrash() {
+    const trashList = document.getElementById('trash-list');
+
+    fetch('/api/requestTrash', {
+        method: 'GET',
+    }).then(res => {
+        if (!res.ok) {
+            throw new Error("Failed");
+        }
+        return res.json();
+    }).then(data => {
+        trashList.innerHTML = "";
+
+        if (data.length === 0) {
+            trashList.innerText = "The trash is empty";
+        }
+
+        data.forEach((item) => {
+            const itemP = document.createElement('p');
+            const under = item.kind === "comment" ? ` under #${item.parentpostid}` : "";
+            const image = item.imagepath !== "" ? " [image]" : "";
+            itemP.innerText = `${item.kind} #${item.id}${under} by ${item.username} | deleted by ${item.deletedby} at ${item.deletedat} | ${item.postcontent}${image} `;
+
+            const restoreButton = document.createElement('button');
+            restoreButton.type = "button";
+            restoreButton.innerText = "Restore";
+            restoreButton.addEventListener('click', function() {
+                trashAction('/api/restoreTrash', item.id);
+            });
+
+            const purgeButton = document.createElement('button');
+            purgeButton.type = "button";
+            purgeButton.innerText = "Delete Forever";
+            purgeButton.addEventListener('click', function() {
+                if (!confirm(`Delete ${item.kind} #${item.id} for good?`)) {
+                    return;
+                }
+
+                trashAction('/api/purgeTrash', item.id);
+            });
+
+            itemP.appendChild(restoreButton);
+            itemP.appendChild(purgeButton);
+            trashList.appendChild(itemP);
+        });
+    }).catch(error => {
+        console.error("Error:", error);
+    });
+}
+
+async function trashHandler() {
+    document.getElementById('refresh-trash-button').addEventListener('click', function() {
+        loadTrash();
+    });
+
+    loadTrash();
+}
+
+async function revisionHandler() {
+    const revisionList = document.getElementById('revision-list');
+
+    document.getElementById('revision-button').addEventListener('click', function() {
+        const params = new URLSearchParams({ id: document.getElementById('revision-id').value });
+
+        fetch('/api/requestRevisions?' + params.toString(), {
+            method: 'GET',
+        }).then(res => res.json().then(data => {
+            if (!res.ok) throw new Error(data.error || res.statusText);
+            return data;
+        })).then(data => {
+            revisionList.innerHTML = "";
+
+            const headerP = document.createElement('p');
+            const edited = data.editedat === "" ? "never edited" : `last edited ${data.editedat}`;
+            headerP.innerText = `${data.kind} #${data.id} by ${data.username} at ${data.timestamp}, ${edited}`;
+            revisionList.appendChild(headerP);
+
+            data.revisions.forEach((revision, i) => {
+                const revisionP = document.createElement('p');
+                revisionP.innerText = `v${i + 1} | replaced by ${revision.editedby} at ${revision.createdat} | ${revision.postcontent}`;
+                revisionList.appendChild(revisionP);
+            });
+
+            const currentP = document.createElement('p');
+            currentP.innerText = `current | ${data.postcontent}`;
+            revisionList.appendChild(currentP);
+        }).catch(error => {
+            revisionList.innerText = error.message;
+            console.error("Error:", error);
+        });
+    });
+}
+
+function loadLockouts() {
+    const lockoutList = document.getElementById('lockout-list');
+
+    fetch('/api/requestLockouts', {
+        method: 'GET',
+    }).then(res => {
+        if (!res.ok) {
+            throw new Error("Failed");
+        }
+        return res.json();
+    }).then(data => {
+        lockoutList.innerHTML = "";
+
+        if (data.length === 0) {
+            lockoutList.innerText = "No lockouts";
+        }
+
+        data.forEach((lockout) => {
+            const lockoutP = document.createElement('p');
+            lockoutP.innerText = `${lockout.createdat} | ${lockout.kind} ${lockout.subject} | ${lockout.failures} failures | locked until ${lockout.lockeduntil}`;
+            lockoutList.appendChild(lockoutP);
+        });
+    }).catch(error => {
+        console.error("Error:", error);
+    });
+}
+
+async function lockoutHandler() {
+    document.getElementById('refresh-lockouts-button').addEventListener('click', function() {
+        loadLockouts();
+    });
+
+    loadLockouts();
+}
+
 document.addEventListener("DOMContentLoaded", (event) => {
     returnButton();
     announcementHandler();
     emoticonHandler();
+    userHandler();
+    banHandler();
+    sessionHandler();
+    inviteHandler();
+    trashHandler();
+    revisionHandler();
+    lockoutHandler();
 
     /*
     const formData = new FormData();